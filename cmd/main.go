@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/otlp"
 	"github.com/nicolastakashi/jaeger-redisearch/internal/repository"
 	"github.com/nicolastakashi/jaeger-redisearch/internal/store"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -27,6 +31,10 @@ var configPath string
 
 func main() {
 	flag.StringVar(&configPath, "config", "", "A path to the plugin's configuration file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [indexer]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	logger := hclog.New(&hclog.LoggerOptions{
@@ -48,7 +56,11 @@ func main() {
 		}
 	}
 
-	config := model.InitFromViper(v)
+	config, err := model.InitFromViper(v)
+	if err != nil {
+		logger.Error("failed to parse configuration", "err", err)
+		os.Exit(1)
+	}
 
 	redisClientOptions := rueidis.ClientOption{
 		InitAddress:      config.RedisAddresses,
@@ -73,41 +85,132 @@ func main() {
 
 	defer c.Close()
 
-	spanRepository, err := repository.NewSpanRepository(logger, c, config)
+	resilience := repository.NewResilience(config)
+
+	serviceRepository, err := repository.NewOperationRepository(logger, c, config, resilience)
 
 	if err != nil {
 		logger.Error("error to create span repository", err)
 		os.Exit(1)
 	}
 
-	serviceRepository, err := repository.NewOperationRepository(logger, c, config)
+	defer serviceRepository.Close()
+
+	spanRepository, err := repository.NewSpanRepository(logger, c, config, serviceRepository, resilience)
 
 	if err != nil {
 		logger.Error("error to create span repository", err)
 		os.Exit(1)
 	}
 
+	// Deferred after the operation repository, so the operations of the spans written in the background are registered.
+	defer spanRepository.Close()
+
+	// Documents written by earlier versions are rewritten in the background.
+	go func() {
+		if err := repository.Migrate(context.Background(), logger, c); err != nil {
+			logger.Error("error to migrate documents", "err", err)
+		}
+	}()
+
+	var spanStream *repository.SpanStream
+
+	if config.StreamEnabled {
+		spanStream, err = repository.NewSpanStream(logger, c, config)
+
+		if err != nil {
+			logger.Error("error to create span stream", "err", err)
+			os.Exit(1)
+		}
+	}
+
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
+		// Replies 503 while the circuit breaker is open and calls to Redis fail fast.
+		http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+			if !resilience.Healthy() {
+				http.Error(w, repository.ErrCircuitOpen.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
 		err = http.ListenAndServe(fmt.Sprintf(":%v", config.HttpPort), nil)
 		if err != nil {
 			logger.Error("Failed to listen for metrics endpoint", "error", err)
 		}
 	}()
 
+	if flag.Arg(0) == "indexer" {
+		runIndexer(logger, spanStream, spanRepository, config)
+		return
+	}
+
+	if spanStream != nil && config.StreamConsumers > 0 {
+		indexer := store.NewIndexer(logger, spanStream, spanRepository, config.StreamConsumers, config)
+		indexer.Start()
+		defer indexer.Close()
+	}
+
+	spanWriter, err := store.NewSpanWriter(logger, spanRepository, spanStream, config)
+
+	if err != nil {
+		logger.Error("error to create span writer", err)
+		os.Exit(1)
+	}
+
+	// Deferred after the client, so queued spans are written before the connection is closed.
+	defer spanWriter.Close()
+
+	if config.OTLPGrpcEndpoint != "" || config.OTLPHttpEndpoint != "" {
+		receiver := otlp.NewReceiver(logger, spanWriter, config)
+
+		if err := receiver.Start(); err != nil {
+			logger.Error("error to start OTLP receiver", "err", err)
+			os.Exit(1)
+		}
+
+		// Deferred after the span writer, so the requests in flight are written before the writer is closed.
+		defer receiver.Close()
+	}
+
 	plugin := &RedisStorePlugin{
-		writer: store.NewSpanWriter(logger, spanRepository, serviceRepository),
-		reader: store.NewSpanReader(logger, spanRepository, serviceRepository),
+		writer:          spanWriter,
+		streamingWriter: store.NewStreamingSpanWriter(spanWriter),
+		reader:          store.NewSpanReader(logger, spanRepository, serviceRepository),
 	}
 
 	grpc.Serve(&shared.PluginServices{
-		Store: plugin,
+		Store:               plugin,
+		StreamingSpanWriter: plugin,
 	})
 }
 
+// runIndexer only indexes the spans appended to the ingestion stream, until the process is asked to stop.
+func runIndexer(logger hclog.Logger, spanStream *repository.SpanStream, spanRepository *repository.SpanRepository, config model.Configuration) {
+	if spanStream == nil {
+		logger.Error("the indexer requires stream_enabled to be set")
+		os.Exit(1)
+	}
+
+	consumers := config.StreamConsumers
+	if consumers < 1 {
+		consumers = 1
+	}
+
+	indexer := store.NewIndexer(logger, spanStream, spanRepository, consumers, config)
+	indexer.Start()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	indexer.Close()
+}
+
 type RedisStorePlugin struct {
-	reader *store.SpanReader
-	writer *store.SpanWriter
+	reader          *store.SpanReader
+	writer          *store.SpanWriter
+	streamingWriter *store.StreamingSpanWriter
 }
 
 func (s *RedisStorePlugin) DependencyReader() dependencystore.Reader {
@@ -121,3 +224,7 @@ func (s *RedisStorePlugin) SpanReader() spanstore.Reader {
 func (s *RedisStorePlugin) SpanWriter() spanstore.Writer {
 	return s.writer
 }
+
+func (s *RedisStorePlugin) StreamingSpanWriter() spanstore.Writer {
+	return s.streamingWriter
+}
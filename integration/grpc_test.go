@@ -97,6 +97,12 @@ func (s *GRPCStorageIntegrationTestSuite) cleanUp() error {
 }
 
 func TestGRPCStorage(t *testing.T) {
+	s := newGRPCStorageIntegrationTestSuite(t)
+	s.IntegrationTestAll(t)
+}
+
+// newGRPCStorageIntegrationTestSuite starts the plugin, skipping the test unless the STORAGE env var is set to grpc-plugin.
+func newGRPCStorageIntegrationTestSuite(t testing.TB) *GRPCStorageIntegrationTestSuite {
 	if os.Getenv("STORAGE") != "grpc-plugin" {
 		t.Skip("Integration test against grpc skipped; set STORAGE env var to grpc-plugin to run this")
 	}
@@ -127,6 +133,5 @@ func TestGRPCStorage(t *testing.T) {
 	}
 
 	require.NoError(t, s.initialize())
-
-	s.IntegrationTestAll(t)
+	return s
 }
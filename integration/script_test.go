@@ -0,0 +1,40 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGRPCStorageWritesAfterScriptFlush checks spans are still written once the script cache of the server is flushed,
+// as after a restart or a failover, the span write script being sent again through EVAL.
+func TestGRPCStorageWritesAfterScriptFlush(t *testing.T) {
+	s := newGRPCStorageIntegrationTestSuite(t)
+	require.NoError(t, s.CleanUp())
+
+	require.NoError(t, s.client.Do(context.Background(), s.client.B().ScriptFlush().Build()).Error())
+
+	span := &model.Span{
+		TraceID:       model.NewTraceID(0, 42),
+		SpanID:        model.NewSpanID(42),
+		OperationName: "flushed",
+		StartTime:     time.Now().Add(-time.Minute).Truncate(time.Millisecond),
+		Duration:      time.Millisecond,
+		Process:       model.NewProcess("api", nil),
+	}
+	require.NoError(t, s.SpanWriter.WriteSpan(context.Background(), span))
+
+	// Spans may be written in the background.
+	var trace *model.Trace
+	require.Eventually(t, func() bool {
+		var err error
+		trace, err = s.SpanReader.GetTrace(context.Background(), span.TraceID)
+		return err == nil && len(trace.Spans) == 1
+	}, 10*time.Second, 100*time.Millisecond)
+
+	assert.Equal(t, "flushed", trace.Spans[0].OperationName)
+}
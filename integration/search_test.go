@@ -0,0 +1,154 @@
+package integration
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGRPCStorageSearchMatchesExactly checks searches only find the spans holding a tag with the value searched,
+// and the spans of the service searched.
+func TestGRPCStorageSearchMatchesExactly(t *testing.T) {
+	s := newGRPCStorageIntegrationTestSuite(t)
+	require.NoError(t, s.CleanUp())
+
+	start := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	newSpan := func(traceID uint64, service string, tags []model.KeyValue, processTags []model.KeyValue, logFields []model.KeyValue) *model.Span {
+		span := &model.Span{
+			TraceID:       model.NewTraceID(0, traceID),
+			SpanID:        model.NewSpanID(traceID),
+			OperationName: "search",
+			StartTime:     start,
+			Duration:      time.Millisecond,
+			Tags:          tags,
+			Process:       model.NewProcess(service, processTags),
+		}
+		if len(logFields) > 0 {
+			span.Logs = []model.Log{{Timestamp: start, Fields: logFields}}
+		}
+		return span
+	}
+
+	spans := []*model.Span{
+		// error=true: key and value held by different tags.
+		newSpan(1, "api", []model.KeyValue{model.Bool("error", false), model.String("retried", "true")}, nil, nil),
+		// region=eu: key held by a process tag, value by a log field.
+		newSpan(2, "api", nil, []model.KeyValue{model.String("region", "us")}, []model.KeyValue{model.String("zone", "eu")}),
+		// Matching spans, with the tag held by a span tag, a process tag and a log field.
+		newSpan(3, "api", []model.KeyValue{model.Bool("error", true)}, nil, nil),
+		newSpan(4, "api", nil, []model.KeyValue{model.String("region", "eu")}, nil),
+		newSpan(5, "api", nil, nil, []model.KeyValue{model.String("region", "eu")}),
+		// Service whose name holds the one searched.
+		newSpan(6, "api-gateway", []model.KeyValue{model.Bool("error", true)}, nil, nil),
+	}
+	for _, span := range spans {
+		require.NoError(t, s.SpanWriter.WriteSpan(context.Background(), span))
+	}
+
+	find := func(tags map[string]string) []model.TraceID {
+		traces, err := s.SpanReader.FindTraces(context.Background(), &spanstore.TraceQueryParameters{
+			ServiceName:  "api",
+			Tags:         tags,
+			StartTimeMin: start.Add(-time.Minute),
+			StartTimeMax: start.Add(time.Minute),
+			NumTraces:    10,
+		})
+		require.NoError(t, err)
+
+		ids := []model.TraceID{}
+		for _, trace := range traces {
+			ids = append(ids, trace.Spans[0].TraceID)
+		}
+		return ids
+	}
+
+	// Spans may be written in the background.
+	require.Eventually(t, func() bool {
+		return len(find(nil)) == 5
+	}, 10*time.Second, 100*time.Millisecond)
+
+	assert.ElementsMatch(t, []model.TraceID{model.NewTraceID(0, 3)}, find(map[string]string{"error": "true"}))
+	assert.ElementsMatch(t, []model.TraceID{model.NewTraceID(0, 4), model.NewTraceID(0, 5)}, find(map[string]string{"region": "eu"}))
+	assert.ElementsMatch(t, []model.TraceID{}, find(map[string]string{"retried": "false"}))
+}
+
+// FuzzGRPCStorageFindsHostileNames checks spans whose service, operation and tag names hold query syntax are stored as is
+// and found through FindTraceIDs by these names, and only by them.
+func FuzzGRPCStorageFindsHostileNames(f *testing.F) {
+	for _, seed := range []string{
+		"frontend",
+		"HTTP GET /customer",
+		"a,b",
+		"{x}|y",
+		"-negated",
+		"@field:{value}",
+		`"quoted"`,
+		`back\slash`,
+		"spaces and\ttabs",
+		"wild*card",
+		"%fuzzy%",
+		"key=value",
+		"$.path[0]",
+		"Mixed Case",
+		"日本 語",
+		"unit\x1fseparator",
+	} {
+		f.Add(seed)
+	}
+
+	s := newGRPCStorageIntegrationTestSuite(f)
+	require.NoError(f, s.CleanUp())
+
+	start := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	traceID := uint64(0)
+
+	f.Fuzz(func(t *testing.T, name string) {
+		if name == "" || !utf8.ValidString(name) {
+			t.Skip("names are non empty valid UTF-8")
+		}
+
+		// A span of a service whose name only differs by case and a suffix, holding the tag with another value, must not be found.
+		write := func(service string, operation string, key string, value string) model.TraceID {
+			traceID++
+			span := &model.Span{
+				TraceID:       model.NewTraceID(0, traceID),
+				SpanID:        model.NewSpanID(traceID),
+				OperationName: operation,
+				StartTime:     start,
+				Duration:      time.Millisecond,
+				Tags:          []model.KeyValue{model.String(key, value)},
+				Process:       model.NewProcess(service, nil),
+			}
+			require.NoError(t, s.SpanWriter.WriteSpan(context.Background(), span))
+			return span.TraceID
+		}
+		want := write(name, name+" operation", name, name)
+		write(strings.ToUpper(name)+"x", name+" operation", name, name+"x")
+
+		query := &spanstore.TraceQueryParameters{
+			ServiceName:   name,
+			OperationName: name + " operation",
+			Tags:          map[string]string{name: name},
+			StartTimeMin:  start.Add(-time.Minute),
+			StartTimeMax:  start.Add(time.Minute),
+			NumTraces:     10,
+		}
+
+		// Spans may be written in the background.
+		var found []model.TraceID
+		require.Eventually(t, func() bool {
+			var err error
+			found, err = s.SpanReader.FindTraceIDs(context.Background(), query)
+			require.NoError(t, err)
+			return len(found) > 0
+		}, 10*time.Second, 100*time.Millisecond, "span of %q not found", name)
+		assert.Equal(t, []model.TraceID{want}, found)
+	})
+}
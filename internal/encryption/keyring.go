@@ -0,0 +1,247 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+)
+
+var errEmptyKeyFile = errors.New("encryption key file holds no key")
+
+// Keyring encrypts the parts of the span documents the index does not need with AES-GCM.
+// Documents are encrypted with the active key and record its id, so keys can be rotated
+// while documents encrypted with older keys are still decrypted.
+// The tag pairs the index needs are replaced by keyed HMAC tokens, so tags are searched without being stored in plaintext.
+type Keyring struct {
+	active    string
+	keys      map[string]cipher.AEAD
+	tokenKeys map[string][]byte
+}
+
+// payload holds the encrypted fields of a span document.
+type payload struct {
+	Tags        []model.KeyValue `json:"tags"`
+	ProcessTags []model.KeyValue `json:"processTags"`
+	Logs        []model.Log      `json:"logs"`
+	Warnings    []string         `json:"warnings"`
+	Blob        string           `json:"blob,omitempty"`
+	TagPairs    []string         `json:"tagPairs,omitempty"`
+}
+
+// LoadKeyring reads the keys from the file at path, one "<id>:<base64 key>" per line, lines starting with # being ignored.
+// Keys are 16, 24 or 32 bytes long. activeID selects the key encrypting new documents, the last key of the file when empty.
+func LoadKeyring(path string, activeID string) (*Keyring, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	k := &Keyring{keys: map[string]cipher.AEAD{}, tokenKeys: map[string][]byte{}}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid encryption key line: expected <id>:<base64 key>")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %s: %w", id, err)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %s: %w", id, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		// Tokens are derived with their own key, so the HMAC never uses the encryption key itself.
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("tag-pairs"))
+
+		k.keys[strings.TrimSpace(id)] = aead
+		k.tokenKeys[strings.TrimSpace(id)] = mac.Sum(nil)
+		k.active = strings.TrimSpace(id)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(k.keys) == 0 {
+		return nil, errEmptyKeyFile
+	}
+
+	if activeID != "" {
+		if _, ok := k.keys[activeID]; !ok {
+			return nil, fmt.Errorf("unknown encryption key %s", activeID)
+		}
+		k.active = activeID
+	}
+
+	return k, nil
+}
+
+// Seal moves the tags, process tags, logs and warnings of the span, or the blob of a compact span, into its encrypted payload.
+// The tag pairs move to the payload as well, replaced by their tokens under the active key.
+// The document key is authenticated along with the payload, so a payload cannot be moved to another document.
+func (k *Keyring) Seal(span *model.Span) error {
+	plaintext, err := json.Marshal(payload{
+		Tags:        span.Tags,
+		ProcessTags: span.Process.Tags,
+		Logs:        span.Logs,
+		Warnings:    span.Warnings,
+		Blob:        span.Blob,
+		TagPairs:    span.TagPairs,
+	})
+	if err != nil {
+		return err
+	}
+
+	span.KeyID = k.active
+	span.Payload, err = k.encrypt(plaintext, span.Key)
+	if err != nil {
+		return err
+	}
+
+	tokens := make([]string, len(span.TagPairs))
+	for i, pair := range span.TagPairs {
+		tokens[i] = k.token(k.active, pair)
+	}
+
+	span.Tags, span.Process.Tags, span.Logs, span.Warnings, span.Blob = nil, nil, nil, nil, ""
+	span.TagPairs = tokens
+	return nil
+}
+
+// Open restores the encrypted fields of the span. Spans stored without encryption are left as is.
+func (k *Keyring) Open(span *model.Span) error {
+	if span.Payload == "" {
+		return nil
+	}
+
+	plaintext, err := k.decrypt(span.KeyID, span.Payload, span.Key)
+	if err != nil {
+		return err
+	}
+
+	decrypted := payload{}
+	if err := json.Unmarshal(plaintext, &decrypted); err != nil {
+		return err
+	}
+
+	span.Tags, span.Process.Tags, span.Logs, span.Warnings = decrypted.Tags, decrypted.ProcessTags, decrypted.Logs, decrypted.Warnings
+	span.Blob, span.TagPairs = decrypted.Blob, decrypted.TagPairs
+	span.KeyID, span.Payload = "", ""
+	return nil
+}
+
+// Tokens returns the token of the tag pair under every key, the tokens a search matches documents sealed with any of them.
+func (k *Keyring) Tokens(pair string) []string {
+	tokens := make([]string, 0, len(k.tokenKeys))
+	for id := range k.tokenKeys {
+		tokens = append(tokens, k.token(id, pair))
+	}
+	return tokens
+}
+
+func (k *Keyring) token(keyID string, pair string) string {
+	mac := hmac.New(sha256.New, k.tokenKeys[keyID])
+	mac.Write([]byte(pair))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SealProcess moves the tags of the process record into its encrypted payload, authenticating the hash of the record along with it.
+// The tag pairs are replaced by their tokens under the active key, they are only searched on and rebuilt from the tags when needed.
+func (k *Keyring) SealProcess(record *model.ProcessRecord, hash string) error {
+	plaintext, err := json.Marshal(record.Tags)
+	if err != nil {
+		return err
+	}
+
+	record.KeyID = k.active
+	record.Payload, err = k.encrypt(plaintext, hash)
+	if err != nil {
+		return err
+	}
+
+	tokens := make([]string, len(record.TagPairs))
+	for i, pair := range record.TagPairs {
+		tokens[i] = k.token(k.active, pair)
+	}
+
+	record.Tags, record.TagPairs = nil, tokens
+	return nil
+}
+
+// OpenProcess restores the tags of the process record. Records stored without encryption are left as is.
+func (k *Keyring) OpenProcess(record *model.ProcessRecord, hash string) error {
+	if record.Payload == "" {
+		return nil
+	}
+
+	plaintext, err := k.decrypt(record.KeyID, record.Payload, hash)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(plaintext, &record.Tags); err != nil {
+		return err
+	}
+
+	record.KeyID, record.Payload = "", ""
+	return nil
+}
+
+// encrypt encrypts plaintext with the active key, returning the nonce followed by the ciphertext, base64 encoded.
+func (k *Keyring) encrypt(plaintext []byte, associated string) (string, error) {
+	aead := k.keys[k.active]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, []byte(associated))), nil
+}
+
+func (k *Keyring) decrypt(keyID string, encrypted string, associated string) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %s", keyID)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted payload is too short")
+	}
+
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(associated))
+}
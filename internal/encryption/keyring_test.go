@@ -0,0 +1,168 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+)
+
+// writeKeyFile writes a key file holding a 32 bytes key for each id.
+func writeKeyFile(t *testing.T, ids ...string) string {
+	t.Helper()
+
+	lines := make([]string, 0, len(ids))
+	for i, id := range ids {
+		key := make([]byte, 32)
+		for j := range key {
+			key[j] = byte(i + j)
+		}
+		lines = append(lines, id+":"+base64.StdEncoding.EncodeToString(key))
+	}
+
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("# keys\n"+strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadKeyring(t *testing.T, path string, activeID string) *Keyring {
+	t.Helper()
+
+	keyring, err := LoadKeyring(path, activeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func testSpan() *model.Span {
+	return &model.Span{
+		Key:           "span-key",
+		TraceID:       "0000000000000001",
+		OperationName: "GET /users",
+		Process:       model.Process{ServiceName: "api", Tags: []model.KeyValue{{Key: "host", Value: "node-one"}}},
+		Tags:          []model.KeyValue{{Key: "user.id", Value: "alice"}},
+		TagPairs:      []string{"user.id=alice", "host=node-one"},
+		Logs:          []model.Log{{Timestamp: 1, Fields: []model.KeyValue{{Key: "event", Value: "login"}}}},
+		Warnings:      []string{"clock skew"},
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	keyring := loadKeyring(t, writeKeyFile(t, "k1"), "")
+
+	span := testSpan()
+	if err := keyring.Seal(span); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := json.Marshal(span)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plaintext := range []string{"alice", "node-one", "login", "clock skew"} {
+		if strings.Contains(string(sealed), plaintext) {
+			t.Errorf("sealed document holds %q in plaintext: %s", plaintext, sealed)
+		}
+	}
+	if span.KeyID != "k1" || span.OperationName != "GET /users" || span.Process.ServiceName != "api" {
+		t.Errorf("sealed document lost the fields the index needs: %s", sealed)
+	}
+	if span.TagPairs[0] != keyring.Tokens("user.id=alice")[0] {
+		t.Errorf("tag pair sealed to %q, want its token", span.TagPairs[0])
+	}
+
+	if err := keyring.Open(span); err != nil {
+		t.Fatal(err)
+	}
+	if want := testSpan(); !reflect.DeepEqual(span, want) {
+		t.Errorf("opened span %+v, want %+v", span, want)
+	}
+}
+
+// TestOpenDropsTokens checks the tokens of a sealed document are replaced by the tag pairs of its payload,
+// even when it has none, so they are never read back as tag pairs.
+func TestOpenDropsTokens(t *testing.T) {
+	keyring := loadKeyring(t, writeKeyFile(t, "k1"), "")
+
+	span := testSpan()
+	span.Tags, span.Process.Tags, span.Logs, span.TagPairs = nil, nil, nil, nil
+	if err := keyring.Seal(span); err != nil {
+		t.Fatal(err)
+	}
+
+	span.TagPairs = keyring.Tokens("user.id=alice")
+	if err := keyring.Open(span); err != nil {
+		t.Fatal(err)
+	}
+	if len(span.TagPairs) != 0 {
+		t.Errorf("opened span tag pairs %v, want none", span.TagPairs)
+	}
+}
+
+// TestKeyRotation checks documents sealed with an older key are opened and found by tag after the active key changes.
+func TestKeyRotation(t *testing.T) {
+	path := writeKeyFile(t, "k1", "k2")
+
+	span := testSpan()
+	if err := loadKeyring(t, path, "k1").Seal(span); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := loadKeyring(t, path, "k2")
+	if tokens := rotated.Tokens("user.id=alice"); len(tokens) != 2 || !containsString(tokens, span.TagPairs[0]) {
+		t.Errorf("tokens %v do not match the token %q of the document sealed with k1", tokens, span.TagPairs[0])
+	}
+	if err := rotated.Open(span); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rotated.Seal(span); err != nil {
+		t.Fatal(err)
+	}
+	if span.KeyID != "k2" {
+		t.Errorf("document sealed with %s, want the active key k2", span.KeyID)
+	}
+}
+
+func TestOpenFailsWithUnknownKey(t *testing.T) {
+	span := testSpan()
+	if err := loadKeyring(t, writeKeyFile(t, "k1"), "").Seal(span); err != nil {
+		t.Fatal(err)
+	}
+
+	span.KeyID = "k0"
+	if err := loadKeyring(t, writeKeyFile(t, "k1"), "").Open(span); err == nil {
+		t.Error("expected opening a document sealed with an unknown key to fail")
+	}
+}
+
+func TestOpenFailsOnAnotherDocument(t *testing.T) {
+	keyring := loadKeyring(t, writeKeyFile(t, "k1"), "")
+
+	span := testSpan()
+	if err := keyring.Seal(span); err != nil {
+		t.Fatal(err)
+	}
+
+	span.Key = "another-key"
+	if err := keyring.Open(span); err == nil {
+		t.Error("expected opening a payload moved to another document to fail")
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -24,3 +24,139 @@ var ReadLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
 	Name: "jaeger_redis_read_latency",
 	Help: "Latency of read in Redis.",
 }, []string{"index", "status", "operation"})
+
+var BatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "jaeger_redis_batch_size",
+	Help:    "Number of spans flushed to Redis per batch.",
+	Buckets: []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000},
+})
+
+var BatchFlushTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_batch_flush_total",
+	Help: "Number of batches flushed to Redis.",
+}, []string{"reason"})
+
+var BatchFlushLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "jaeger_redis_batch_flush_latency",
+	Help: "Latency of batch flushes to Redis.",
+}, []string{"status"})
+
+var QueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "jaeger_redis_queue_depth",
+	Help: "Number of spans waiting in the write queue.",
+})
+
+var QueueDroppedSpans = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_queue_dropped_spans_total",
+	Help: "Number of spans dropped because the write queue was full.",
+}, []string{"policy"})
+
+var OperationCatalogRetries = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "jaeger_redis_operation_catalog_retries_total",
+	Help: "Number of operation catalog writes retried in the background.",
+})
+
+var OperationCatalogDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "jaeger_redis_operation_catalog_dropped_total",
+	Help: "Number of operation catalog writes given up, to be registered again with a later span.",
+})
+
+var StreamEntriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_stream_entries_total",
+	Help: "Number of span entries handled by the ingestion stream.",
+}, []string{"operation"})
+
+var SpilledSpans = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_spilled_spans_total",
+	Help: "Number of spans spilled to local disk while Redis was unavailable, replayed or discarded.",
+}, []string{"operation"})
+
+var ReceivedSpans = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_otlp_received_spans_total",
+	Help: "Number of spans received by the OTLP receiver.",
+}, []string{"transport", "status"})
+
+var GuardrailTruncatedValues = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_guardrail_truncated_values_total",
+	Help: "Number of tag values truncated because they exceeded max_tag_value_length.",
+}, []string{"service"})
+
+var GuardrailDroppedTags = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_guardrail_dropped_tags_total",
+	Help: "Number of tags dropped because the span exceeded max_tags_per_span.",
+}, []string{"service"})
+
+var GuardrailDroppedLogs = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_guardrail_dropped_logs_total",
+	Help: "Number of logs dropped because the span exceeded max_logs_per_span.",
+}, []string{"service"})
+
+var GuardrailDroppedSpans = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_guardrail_dropped_spans_total",
+	Help: "Number of spans dropped because their trace exceeded max_spans_per_trace.",
+}, []string{"service"})
+
+var SamplingDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_sampling_decisions_total",
+	Help: "Number of spans kept or dropped by each sampling rule.",
+}, []string{"rule", "decision"})
+
+var TailSamplingDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_tail_sampling_decisions_total",
+	Help: "Number of traces kept or dropped by tail sampling, by the policy keeping them.",
+}, []string{"policy", "decision"})
+
+var TailSamplingSpans = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_tail_sampling_spans_total",
+	Help: "Number of spans written or discarded by tail sampling.",
+}, []string{"decision"})
+
+var TailSamplingBufferedTraces = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "jaeger_redis_tail_sampling_buffered_traces",
+	Help: "Number of traces waiting for a tail sampling decision.",
+})
+
+var TailSamplingBufferedSpans = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "jaeger_redis_tail_sampling_buffered_spans",
+	Help: "Number of spans waiting for the tail sampling decision of their trace.",
+})
+
+var TailSamplingPendingWrites = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "jaeger_redis_tail_sampling_pending_writes",
+	Help: "Number of kept traces and late spans waiting to be written.",
+})
+
+var RedactedValues = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_redacted_values_total",
+	Help: "Number of sensitive values redacted, by the rule key or detector name finding them.",
+}, []string{"source", "action"})
+
+var OperationOverflow = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_operation_overflow_total",
+	Help: "Number of spans whose operation was folded into the overflow bucket because their service reached max_operations_per_service.",
+}, []string{"service"})
+
+var WriteDurabilityLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "jaeger_redis_write_durability_latency",
+	Help: "Latency of span writes until they are acknowledged as required by the write durability mode.",
+}, []string{"mode", "status"})
+
+var ShedSpans = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_shed_spans_total",
+	Help: "Number of spans shed because their service or the plugin exceeded its ingestion rate limit.",
+}, []string{"service", "priority"})
+
+var RedisRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_retries_total",
+	Help: "Number of Redis calls retried after a transient error.",
+}, []string{"operation"})
+
+var CircuitBreakerState = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "jaeger_redis_circuit_breaker_state",
+	Help: "State of the Redis circuit breaker: 0 closed, 1 half-open, 2 open.",
+})
+
+var CircuitBreakerRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jaeger_redis_circuit_breaker_rejected_total",
+	Help: "Number of Redis calls failed without reaching Redis because the circuit breaker was open.",
+}, []string{"operation"})
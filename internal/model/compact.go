@@ -0,0 +1,84 @@
+package model
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"io"
+	"sync"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+// flateWriters reuses the compressors, each holding about a megabyte of state.
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		writer, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return writer
+	},
+}
+
+// CompactSpan keeps in the document only the fields the index needs: ids, operation, service, times, flags and merged tags.
+// The whole span, protobuf encoded and compressed, is kept in Blob, a single value where the full document holds a value per field of each tag, log and reference.
+func CompactSpan(span *Span, jSpan *jModel.Span) error {
+	data, err := jSpan.Marshal()
+	if err != nil {
+		return err
+	}
+
+	var compressed bytes.Buffer
+	writer := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(writer)
+
+	writer.Reset(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	span.Blob = base64.StdEncoding.EncodeToString(compressed.Bytes())
+	span.References = nil
+	span.ProcessID = ""
+	span.Process.Tags = nil
+	span.Tags = nil
+	span.Logs = nil
+	span.Warnings = nil
+	return nil
+}
+
+// ExpandSpan restores the fields of a compact document from its blob. Documents that are not compact are left as is.
+func ExpandSpan(span *Span) error {
+	if span.Blob == "" {
+		return nil
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(span.Blob)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		return err
+	}
+
+	jSpan := &jModel.Span{}
+	if err := jSpan.Unmarshal(data); err != nil {
+		return err
+	}
+	if jSpan.Process == nil {
+		jSpan.Process = &jModel.Process{}
+	}
+
+	full := ConvertSpanFromJaeger(jSpan)
+	span.References = full.References
+	span.ProcessID = full.ProcessID
+	span.Process.Tags = full.Process.Tags
+	span.Tags = full.Tags
+	span.Logs = full.Logs
+	span.Warnings = full.Warnings
+	span.Blob = ""
+	return nil
+}
@@ -0,0 +1,173 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+// BenchmarkSpanStorage compares the documents stored for a typical server span in each storage mode,
+// reporting their encoded size as bytes/span and their number of JSON values as values/span.
+// RedisJSON keeps each value as a node of a tree, with an overhead of tens of bytes per node,
+// so the memory used by a document grows with both, while the blob of a compact document is a single value.
+func BenchmarkSpanStorage(b *testing.B) {
+	jSpan := benchmarkSpan()
+
+	b.Run("json", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			data, err := json.Marshal(ConvertSpanFromJaeger(jSpan))
+			if err != nil {
+				b.Fatal(err)
+			}
+			size = len(data)
+		}
+		b.ReportMetric(float64(size), "bytes/span")
+		b.ReportMetric(float64(countValues(b, ConvertSpanFromJaeger(jSpan))), "values/span")
+	})
+
+	b.Run("compact", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			span := ConvertSpanFromJaeger(jSpan)
+			if err := CompactSpan(span, jSpan); err != nil {
+				b.Fatal(err)
+			}
+			data, err := json.Marshal(span)
+			if err != nil {
+				b.Fatal(err)
+			}
+			size = len(data)
+		}
+		b.ReportMetric(float64(size), "bytes/span")
+
+		span := ConvertSpanFromJaeger(jSpan)
+		if err := CompactSpan(span, jSpan); err != nil {
+			b.Fatal(err)
+		}
+		b.ReportMetric(float64(countValues(b, span)), "values/span")
+	})
+}
+
+// countValues returns the number of JSON values of the document of the span.
+func countValues(b *testing.B, span *Span) int {
+	data, err := json.Marshal(span)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		b.Fatal(err)
+	}
+
+	var count func(value interface{}) int
+	count = func(value interface{}) int {
+		n := 1
+		switch value := value.(type) {
+		case map[string]interface{}:
+			for _, v := range value {
+				n += count(v)
+			}
+		case []interface{}:
+			for _, v := range value {
+				n += count(v)
+			}
+		}
+		return n
+	}
+	return count(document)
+}
+
+// TestCompactSpanRoundTrip checks the span is read back whole from its compact document.
+func TestCompactSpanRoundTrip(t *testing.T) {
+	jSpan := benchmarkSpan()
+
+	span := ConvertSpanFromJaeger(jSpan)
+	if err := CompactSpan(span, jSpan); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(span)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &Span{}
+	if err := json.Unmarshal(data, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ExpandSpan(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ConvertSpanToJaeger(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(normalizeSpan(jSpan), normalizeSpan(actual)) {
+		t.Fatalf("expected %v, got %v", jSpan, actual)
+	}
+}
+
+func benchmarkSpan() *jModel.Span {
+	start := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	return &jModel.Span{
+		TraceID:       jModel.NewTraceID(0x4bf92f3577b34da6, 0xa3ce929d0e0e4736),
+		SpanID:        jModel.NewSpanID(0x00f067aa0ba902b7),
+		OperationName: "GET /api/v1/orders/{id}",
+		References: []jModel.SpanRef{
+			jModel.NewChildOfRef(jModel.NewTraceID(0x4bf92f3577b34da6, 0xa3ce929d0e0e4736), jModel.NewSpanID(0x53995c3f42cd8ad8)),
+		},
+		Flags:     jModel.Flags(1),
+		StartTime: start,
+		Duration:  42 * time.Millisecond,
+		Tags: []jModel.KeyValue{
+			jModel.String("span.kind", "server"),
+			jModel.String("http.method", "GET"),
+			jModel.String("http.url", "https://shop.example.com/api/v1/orders/8f14e45f"),
+			jModel.String("http.route", "/api/v1/orders/{id}"),
+			jModel.Int64("http.status_code", 200),
+			jModel.String("http.user_agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0 Safari/537.36"),
+			jModel.String("net.peer.ip", "10.42.7.19"),
+			jModel.Int64("net.peer.port", 52814),
+			jModel.String("component", "net/http"),
+			jModel.Bool("sampler.param", true),
+			jModel.String("sampler.type", "const"),
+			jModel.String("internal.span.format", "proto"),
+		},
+		Logs: []jModel.Log{
+			{
+				Timestamp: start.Add(time.Millisecond),
+				Fields: []jModel.KeyValue{
+					jModel.String("event", "cache miss"),
+					jModel.String("key", "orders:8f14e45f"),
+				},
+			},
+			{
+				Timestamp: start.Add(40 * time.Millisecond),
+				Fields: []jModel.KeyValue{
+					jModel.String("event", "query"),
+					jModel.String("db.statement", "SELECT * FROM orders WHERE id = $1"),
+					jModel.Float64("db.duration_ms", 37.5),
+				},
+			},
+		},
+		Process: &jModel.Process{
+			ServiceName: "orders",
+			Tags: []jModel.KeyValue{
+				jModel.String("hostname", "orders-7d9c8b6f5-x2kzq"),
+				jModel.String("ip", "10.42.3.11"),
+				jModel.String("client-uuid", "3f2a9e1c7b5d4e08"),
+				jModel.String("jaeger.version", "Go-2.30.0"),
+				jModel.String("service.version", "1.14.2"),
+			},
+		},
+	}
+}
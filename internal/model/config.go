@@ -1,22 +1,126 @@
 package model
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Configuration struct {
-	MaxNumSpans       int64         `yaml:"max_num_spans"`
-	HttpPort          string        `yaml:"http_port"`
-	RedisAddresses    []string      `yaml:"redis_addresses"`
-	RedisWriteTimeout time.Duration `yaml:"redis_write_timeout"`
-	RedisTTL          time.Duration `yaml:"redis_ttl"`
-	RedisPassword     string        `yaml:"redis_password"`
-	RedisUsername     string        `yaml:"redis_username"`
+	MaxNumSpans              int64                `yaml:"max_num_spans"`
+	HttpPort                 string               `yaml:"http_port"`
+	RedisAddresses           []string             `yaml:"redis_addresses"`
+	RedisWriteTimeout        time.Duration        `yaml:"redis_write_timeout"`
+	RedisTTL                 time.Duration        `yaml:"redis_ttl"`
+	RedisPassword            string               `yaml:"redis_password"`
+	RedisUsername            string               `yaml:"redis_username"`
+	RedisScripting           bool                 `yaml:"redis_scripting"`
+	SpanMerge                bool                 `yaml:"span_merge"`
+	SpanStorage              string               `yaml:"span_storage_mode"`
+	ProcessInterning         bool                 `yaml:"process_interning"`
+	WriteDurability          string               `yaml:"write_durability"`
+	WriteReplicas            int64                `yaml:"write_replicas"`
+	WriteReplicaTimeout      time.Duration        `yaml:"write_replica_timeout"`
+	RetryMaxAttempts         int                  `yaml:"redis_retry_max_attempts"`
+	RetryBackoff             time.Duration        `yaml:"redis_retry_backoff"`
+	RetryMaxBackoff          time.Duration        `yaml:"redis_retry_max_backoff"`
+	BreakerFailures          int                  `yaml:"circuit_breaker_failures"`
+	BreakerOpenDuration      time.Duration        `yaml:"circuit_breaker_open_duration"`
+	EncryptionKeyFile        string               `yaml:"encryption_key_file"`
+	EncryptionKeyID          string               `yaml:"encryption_key_id"`
+	MaxTagValueLength        int                  `yaml:"max_tag_value_length"`
+	MaxTagsPerSpan           int                  `yaml:"max_tags_per_span"`
+	MaxLogsPerSpan           int                  `yaml:"max_logs_per_span"`
+	MaxSpansPerTrace         int64                `yaml:"max_spans_per_trace"`
+	StreamEnabled            bool                 `yaml:"stream_enabled"`
+	StreamName               string               `yaml:"stream_name"`
+	StreamGroup              string               `yaml:"stream_group"`
+	StreamConsumers          int                  `yaml:"stream_consumers"`
+	StreamMaxLen             int64                `yaml:"stream_max_len"`
+	StreamBatchSize          int64                `yaml:"stream_batch_size"`
+	StreamBlock              time.Duration        `yaml:"stream_block"`
+	StreamClaimIdle          time.Duration        `yaml:"stream_claim_idle"`
+	SpillPath                string               `yaml:"spill_path"`
+	SpillMaxSize             int64                `yaml:"spill_max_size"`
+	SpillReplay              time.Duration        `yaml:"spill_replay_interval"`
+	BatchSize                int                  `yaml:"batch_size"`
+	BatchLinger              time.Duration        `yaml:"batch_linger"`
+	QueueCapacity            int                  `yaml:"queue_capacity"`
+	QueueWorkers             int                  `yaml:"queue_workers"`
+	QueueOverflow            string               `yaml:"queue_overflow_policy"`
+	OTLPGrpcEndpoint         string               `yaml:"otlp_grpc_endpoint"`
+	OTLPHttpEndpoint         string               `yaml:"otlp_http_endpoint"`
+	OTLPMaxRequestSize       int64                `yaml:"otlp_max_request_size"`
+	OperationRules           []OperationRule      `yaml:"operation_normalization"`
+	MaxOperationsPerService  int                  `yaml:"max_operations_per_service"`
+	OperationOverflowName    string               `yaml:"operation_overflow_name"`
+	AttributeProcessors      []AttributeProcessor `yaml:"attribute_processors"`
+	RedactionKey             string               `yaml:"redaction_hmac_key"`
+	RedactionDetectors       []RedactionDetector  `yaml:"redaction_detectors"`
+	RedactionRules           []RedactionRule      `yaml:"redaction_rules"`
+	RateLimitService         float64              `yaml:"rate_limit_service"`
+	RateLimitServiceBurst    float64              `yaml:"rate_limit_service_burst"`
+	RateLimitServices        map[string]float64   `yaml:"rate_limit_services"`
+	RateLimitGlobal          float64              `yaml:"rate_limit_global"`
+	RateLimitGlobalBurst     float64              `yaml:"rate_limit_global_burst"`
+	RateLimitReserve         float64              `yaml:"rate_limit_priority_reserve"`
+	SamplingRules            []SamplingRule       `yaml:"sampling_rules"`
+	TailSampling             bool                 `yaml:"tail_sampling_enabled"`
+	TailSamplingWait         time.Duration        `yaml:"tail_sampling_decision_wait"`
+	TailSamplingMaxTraces    int                  `yaml:"tail_sampling_max_traces"`
+	TailSamplingMaxSpans     int                  `yaml:"tail_sampling_max_spans"`
+	TailSamplingErrors       bool                 `yaml:"tail_sampling_keep_errors"`
+	TailSamplingRootDuration time.Duration        `yaml:"tail_sampling_min_root_duration"`
+	TailSamplingServices     []string             `yaml:"tail_sampling_services"`
+	TailSamplingRate         float64              `yaml:"tail_sampling_rate"`
 }
 
-func InitFromViper(v *viper.Viper) Configuration {
+// OperationRule rewrites the parts of the operation names matching Pattern with Replacement, which may refer to the groups of Pattern.
+type OperationRule struct {
+	Pattern     string `yaml:"pattern" mapstructure:"pattern"`
+	Replacement string `yaml:"replacement" mapstructure:"replacement"`
+}
+
+// AttributeProcessor is a step of the chain rewriting the tags of the spans before they are stored.
+type AttributeProcessor struct {
+	Name        string   `yaml:"name" mapstructure:"name"`
+	Action      string   `yaml:"action" mapstructure:"action"`
+	Key         string   `yaml:"key" mapstructure:"key"`
+	To          string   `yaml:"to" mapstructure:"to"`
+	Value       string   `yaml:"value" mapstructure:"value"`
+	Pattern     string   `yaml:"pattern" mapstructure:"pattern"`
+	Replacement string   `yaml:"replacement" mapstructure:"replacement"`
+	Targets     []string `yaml:"targets" mapstructure:"targets"`
+}
+
+// RedactionDetector finds sensitive data in tag values, either a built-in detector selected by name or a regex pattern.
+type RedactionDetector struct {
+	Name    string `yaml:"name" mapstructure:"name"`
+	Pattern string `yaml:"pattern" mapstructure:"pattern"`
+	Action  string `yaml:"action" mapstructure:"action"`
+}
+
+// RedactionRule redacts the whole value of the tags with the given key.
+type RedactionRule struct {
+	Key    string `yaml:"key" mapstructure:"key"`
+	Action string `yaml:"action" mapstructure:"action"`
+}
+
+// SamplingRule decides whether the spans it matches are stored.
+// Empty criteria match every span.
+type SamplingRule struct {
+	Name        string            `yaml:"name" mapstructure:"name"`
+	Service     string            `yaml:"service" mapstructure:"service"`
+	Operation   string            `yaml:"operation" mapstructure:"operation"`
+	Tags        map[string]string `yaml:"tags" mapstructure:"tags"`
+	MinDuration time.Duration     `yaml:"min_duration" mapstructure:"min_duration"`
+	MaxDuration time.Duration     `yaml:"max_duration" mapstructure:"max_duration"`
+	Action      string            `yaml:"action" mapstructure:"action"`
+	Rate        float64           `yaml:"rate" mapstructure:"rate"`
+}
+
+func InitFromViper(v *viper.Viper) (Configuration, error) {
 	config := Configuration{}
 
 	v.SetDefault("max_num_spans", 10)
@@ -26,6 +130,59 @@ func InitFromViper(v *viper.Viper) Configuration {
 	v.SetDefault("http_port", "9090")
 	v.SetDefault("redis_password", "")
 	v.SetDefault("redis_username", "")
+	v.SetDefault("redis_scripting", true)
+	v.SetDefault("span_merge", false)
+	v.SetDefault("span_storage_mode", "json")
+	v.SetDefault("process_interning", false)
+	v.SetDefault("write_durability", "primary-ack")
+	v.SetDefault("write_replicas", 1)
+	v.SetDefault("write_replica_timeout", time.Millisecond*100)
+	v.SetDefault("redis_retry_max_attempts", 3)
+	v.SetDefault("redis_retry_backoff", time.Millisecond*100)
+	v.SetDefault("redis_retry_max_backoff", time.Second*2)
+	v.SetDefault("circuit_breaker_failures", 5)
+	v.SetDefault("circuit_breaker_open_duration", time.Second*30)
+	v.SetDefault("encryption_key_file", "")
+	v.SetDefault("encryption_key_id", "")
+	v.SetDefault("max_tag_value_length", 0)
+	v.SetDefault("max_tags_per_span", 0)
+	v.SetDefault("max_logs_per_span", 0)
+	v.SetDefault("max_spans_per_trace", 0)
+	v.SetDefault("stream_enabled", false)
+	v.SetDefault("stream_name", "jaeger-spans")
+	v.SetDefault("stream_group", "indexers")
+	v.SetDefault("stream_consumers", 1)
+	v.SetDefault("stream_max_len", 1000000)
+	v.SetDefault("stream_batch_size", 100)
+	v.SetDefault("stream_block", time.Second)
+	v.SetDefault("stream_claim_idle", time.Minute)
+	v.SetDefault("spill_path", "")
+	v.SetDefault("spill_max_size", "1GB")
+	v.SetDefault("spill_replay_interval", time.Second*5)
+	v.SetDefault("batch_size", 100)
+	v.SetDefault("batch_linger", time.Millisecond*10)
+	v.SetDefault("queue_capacity", 0)
+	v.SetDefault("queue_workers", 100)
+	v.SetDefault("queue_overflow_policy", "block")
+	v.SetDefault("otlp_grpc_endpoint", "")
+	v.SetDefault("otlp_http_endpoint", "")
+	v.SetDefault("otlp_max_request_size", "4MB")
+	v.SetDefault("max_operations_per_service", 0)
+	v.SetDefault("operation_overflow_name", "other")
+	v.SetDefault("redaction_hmac_key", "")
+	v.SetDefault("rate_limit_service", 0)
+	v.SetDefault("rate_limit_service_burst", 0)
+	v.SetDefault("rate_limit_global", 0)
+	v.SetDefault("rate_limit_global_burst", 0)
+	v.SetDefault("rate_limit_priority_reserve", 0.2)
+	v.SetDefault("tail_sampling_enabled", false)
+	v.SetDefault("tail_sampling_decision_wait", time.Second*10)
+	v.SetDefault("tail_sampling_max_traces", 100000)
+	v.SetDefault("tail_sampling_max_spans", 1000000)
+	v.SetDefault("tail_sampling_keep_errors", true)
+	v.SetDefault("tail_sampling_min_root_duration", 0)
+	v.SetDefault("tail_sampling_services", []string{})
+	v.SetDefault("tail_sampling_rate", 0)
 
 	config.MaxNumSpans = v.GetInt64("max_num_spans")
 	config.RedisAddresses = v.GetStringSlice("redis_addresses")
@@ -34,6 +191,87 @@ func InitFromViper(v *viper.Viper) Configuration {
 	config.HttpPort = v.GetString("http_port")
 	config.RedisPassword = v.GetString("redis_password")
 	config.RedisUsername = v.GetString("redis_username")
+	config.RedisScripting = v.GetBool("redis_scripting")
+	config.SpanMerge = v.GetBool("span_merge")
+	config.SpanStorage = v.GetString("span_storage_mode")
+	config.ProcessInterning = v.GetBool("process_interning")
+	config.WriteDurability = v.GetString("write_durability")
+	config.WriteReplicas = v.GetInt64("write_replicas")
+	config.WriteReplicaTimeout = v.GetDuration("write_replica_timeout")
+	config.RetryMaxAttempts = v.GetInt("redis_retry_max_attempts")
+	config.RetryBackoff = v.GetDuration("redis_retry_backoff")
+	config.RetryMaxBackoff = v.GetDuration("redis_retry_max_backoff")
+	config.BreakerFailures = v.GetInt("circuit_breaker_failures")
+	config.BreakerOpenDuration = v.GetDuration("circuit_breaker_open_duration")
+	config.EncryptionKeyFile = v.GetString("encryption_key_file")
+	config.EncryptionKeyID = v.GetString("encryption_key_id")
+	config.MaxTagValueLength = v.GetInt("max_tag_value_length")
+	config.MaxTagsPerSpan = v.GetInt("max_tags_per_span")
+	config.MaxLogsPerSpan = v.GetInt("max_logs_per_span")
+	config.MaxSpansPerTrace = v.GetInt64("max_spans_per_trace")
+	config.StreamEnabled = v.GetBool("stream_enabled")
+	config.StreamName = v.GetString("stream_name")
+	config.StreamGroup = v.GetString("stream_group")
+	config.StreamConsumers = v.GetInt("stream_consumers")
+	config.StreamMaxLen = v.GetInt64("stream_max_len")
+	config.StreamBatchSize = v.GetInt64("stream_batch_size")
+	config.StreamBlock = v.GetDuration("stream_block")
+	config.StreamClaimIdle = v.GetDuration("stream_claim_idle")
+	config.SpillPath = v.GetString("spill_path")
+	config.SpillMaxSize = int64(v.GetSizeInBytes("spill_max_size"))
+	config.SpillReplay = v.GetDuration("spill_replay_interval")
+	config.BatchSize = v.GetInt("batch_size")
+	config.BatchLinger = v.GetDuration("batch_linger")
+	config.QueueCapacity = v.GetInt("queue_capacity")
+	config.QueueWorkers = v.GetInt("queue_workers")
+	config.QueueOverflow = v.GetString("queue_overflow_policy")
+	config.OTLPGrpcEndpoint = v.GetString("otlp_grpc_endpoint")
+	config.OTLPHttpEndpoint = v.GetString("otlp_http_endpoint")
+	config.OTLPMaxRequestSize = int64(v.GetSizeInBytes("otlp_max_request_size"))
+	config.MaxOperationsPerService = v.GetInt("max_operations_per_service")
+	config.OperationOverflowName = v.GetString("operation_overflow_name")
+	config.RedactionKey = v.GetString("redaction_hmac_key")
+	config.RateLimitService = v.GetFloat64("rate_limit_service")
+	config.RateLimitServiceBurst = v.GetFloat64("rate_limit_service_burst")
+	config.RateLimitGlobal = v.GetFloat64("rate_limit_global")
+	config.RateLimitGlobalBurst = v.GetFloat64("rate_limit_global_burst")
+	config.RateLimitReserve = v.GetFloat64("rate_limit_priority_reserve")
+	config.TailSampling = v.GetBool("tail_sampling_enabled")
+	config.TailSamplingWait = v.GetDuration("tail_sampling_decision_wait")
+	config.TailSamplingMaxTraces = v.GetInt("tail_sampling_max_traces")
+	config.TailSamplingMaxSpans = v.GetInt("tail_sampling_max_spans")
+	config.TailSamplingErrors = v.GetBool("tail_sampling_keep_errors")
+	config.TailSamplingRootDuration = v.GetDuration("tail_sampling_min_root_duration")
+	config.TailSamplingServices = v.GetStringSlice("tail_sampling_services")
+	config.TailSamplingRate = v.GetFloat64("tail_sampling_rate")
+
+	if err := v.UnmarshalKey("operation_normalization", &config.OperationRules); err != nil {
+		return config, fmt.Errorf("invalid operation normalization rules: %w", err)
+	}
+
+	if err := v.UnmarshalKey("attribute_processors", &config.AttributeProcessors); err != nil {
+		return config, fmt.Errorf("invalid attribute processors: %w", err)
+	}
+
+	if err := v.UnmarshalKey("redaction_detectors", &config.RedactionDetectors); err != nil {
+		return config, fmt.Errorf("invalid redaction detectors: %w", err)
+	}
+
+	if err := v.UnmarshalKey("redaction_rules", &config.RedactionRules); err != nil {
+		return config, fmt.Errorf("invalid redaction rules: %w", err)
+	}
+
+	if err := v.UnmarshalKey("rate_limit_services", &config.RateLimitServices); err != nil {
+		return config, fmt.Errorf("invalid service rate limits: %w", err)
+	}
+
+	if err := v.UnmarshalKey("sampling_rules", &config.SamplingRules); err != nil {
+		return config, fmt.Errorf("invalid sampling rules: %w", err)
+	}
+
+	if config.SpillPath != "" && config.SpillReplay <= 0 {
+		return config, fmt.Errorf("invalid spill replay interval %v: must be positive", config.SpillReplay)
+	}
 
-	return config
+	return config, nil
 }
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MergeSpan folds into dst the data of src that dst does not have yet.
+// It is used when the same span is received more than once, so retries are no-ops
+// and late-arriving tags, logs, references and warnings are not lost.
+func MergeSpan(dst *Span, src *Span) {
+	if dst.OperationName == "" {
+		dst.OperationName = src.OperationName
+	}
+	if dst.StartTime == 0 {
+		dst.StartTime = src.StartTime
+	}
+	dst.Flags |= src.Flags
+	if dst.Duration < src.Duration {
+		dst.Duration = src.Duration
+	}
+	if dst.ProcessID == "" {
+		dst.ProcessID = src.ProcessID
+	}
+	if dst.ProcessHash == "" {
+		dst.ProcessHash = src.ProcessHash
+	}
+	if dst.Process.ServiceName == "" {
+		dst.Process.ServiceName = src.Process.ServiceName
+	}
+
+	dst.Process.Tags = mergeKeyValues(dst.Process.Tags, src.Process.Tags)
+	dst.Tags = mergeKeyValues(dst.Tags, src.Tags)
+
+	for _, pair := range src.TagPairs {
+		if !containsString(dst.TagPairs, pair) {
+			dst.TagPairs = append(dst.TagPairs, pair)
+		}
+	}
+
+	for _, ref := range src.References {
+		if !containsReference(dst.References, ref) {
+			dst.References = append(dst.References, ref)
+		}
+	}
+
+	for _, log := range src.Logs {
+		if !containsLog(dst.Logs, log) {
+			dst.Logs = append(dst.Logs, log)
+		}
+	}
+
+	for _, warning := range src.Warnings {
+		if !containsString(dst.Warnings, warning) {
+			dst.Warnings = append(dst.Warnings, warning)
+		}
+	}
+}
+
+func mergeKeyValues(dst []KeyValue, src []KeyValue) []KeyValue {
+	keys := make(map[string]bool, len(dst))
+	for _, kv := range dst {
+		keys[kv.Key] = true
+	}
+
+	for _, kv := range src {
+		if !keys[kv.Key] {
+			keys[kv.Key] = true
+			dst = append(dst, kv)
+		}
+	}
+	return dst
+}
+
+func containsReference(refs []Reference, ref Reference) bool {
+	for _, r := range refs {
+		if r == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// containsLog compares the logs by their JSON encoding, numbers being json.Number in stored logs and int64 or float64 in new ones.
+func containsLog(logs []Log, log Log) bool {
+	encoded, err := json.Marshal(log)
+	if err != nil {
+		return false
+	}
+
+	for _, l := range logs {
+		if e, err := json.Marshal(l); err == nil && bytes.Equal(e, encoded) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// TestMergeSpanFoldsDuplicate checks merging the same span received twice keeps one copy of what both hold
+// and adds what only the later one holds.
+func TestMergeSpanFoldsDuplicate(t *testing.T) {
+	stored := &Span{
+		TraceID:       "1",
+		SpanID:        "2",
+		OperationName: "GET /users",
+		StartTime:     1000,
+		Duration:      10,
+		Flags:         1,
+		Process:       Process{ServiceName: "api", Tags: []KeyValue{{Key: "hostname", Type: StringType, Value: "node-1"}}},
+		Tags:          []KeyValue{{Key: "http.method", Type: StringType, Value: "GET"}},
+		TagPairs:      []string{"http.method=GET"},
+		References:    []Reference{{RefType: ChildOf, TraceID: "1", SpanID: "1"}},
+		// Stored logs are read back with their numbers as json.Number.
+		Logs:     []Log{{Timestamp: 1001, Fields: []KeyValue{{Key: "retry", Type: Int64Type, Value: json.Number("1")}}}},
+		Warnings: []string{"clock skew"},
+	}
+
+	received := &Span{
+		TraceID:       "1",
+		SpanID:        "2",
+		OperationName: "GET /users/42",
+		StartTime:     1000,
+		Duration:      25,
+		Flags:         2,
+		Process:       Process{ServiceName: "api", Tags: []KeyValue{{Key: "hostname", Type: StringType, Value: "node-1"}, {Key: "pid", Type: Int64Type, Value: int64(42)}}},
+		Tags:          []KeyValue{{Key: "http.method", Type: StringType, Value: "GET"}, {Key: "http.status_code", Type: Int64Type, Value: int64(200)}},
+		TagPairs:      []string{"http.method=GET", "http.status_code=200"},
+		References:    []Reference{{RefType: ChildOf, TraceID: "1", SpanID: "1"}, {RefType: FollowsFrom, TraceID: "1", SpanID: "3"}},
+		Logs: []Log{
+			{Timestamp: 1001, Fields: []KeyValue{{Key: "retry", Type: Int64Type, Value: int64(1)}}},
+			{Timestamp: 1002, Fields: []KeyValue{{Key: "retry", Type: Int64Type, Value: int64(2)}}},
+		},
+		Warnings: []string{"clock skew", "truncated"},
+	}
+
+	MergeSpan(stored, received)
+
+	if stored.OperationName != "GET /users" {
+		t.Errorf("operation name %q, want the stored one", stored.OperationName)
+	}
+	if stored.Duration != 25 || stored.Flags != 3 {
+		t.Errorf("duration %d flags %d, want 25 and 3", stored.Duration, stored.Flags)
+	}
+	if keys := keyValueKeys(stored.Process.Tags); !reflect.DeepEqual(keys, []string{"hostname", "pid"}) {
+		t.Errorf("process tags %v, want hostname and pid", keys)
+	}
+	if keys := keyValueKeys(stored.Tags); !reflect.DeepEqual(keys, []string{"http.method", "http.status_code"}) {
+		t.Errorf("tags %v, want http.method and http.status_code", keys)
+	}
+	if want := []string{"http.method=GET", "http.status_code=200"}; !reflect.DeepEqual(stored.TagPairs, want) {
+		t.Errorf("tag pairs %v, want %v", stored.TagPairs, want)
+	}
+	if len(stored.References) != 2 {
+		t.Errorf("references %v, want 2 of them", stored.References)
+	}
+	if len(stored.Logs) != 2 || stored.Logs[1].Timestamp != 1002 {
+		t.Errorf("logs %v, want the stored one and the one at 1002", stored.Logs)
+	}
+	if want := []string{"clock skew", "truncated"}; !reflect.DeepEqual(stored.Warnings, want) {
+		t.Errorf("warnings %v, want %v", stored.Warnings, want)
+	}
+}
+
+// TestMergeSpanIsIdempotent checks merging a span with an identical copy, as a retried write does, changes nothing.
+func TestMergeSpanIsIdempotent(t *testing.T) {
+	newSpan := func() *Span {
+		return &Span{
+			TraceID:    "1",
+			SpanID:     "2",
+			Duration:   10,
+			Tags:       []KeyValue{{Key: "error", Type: BoolType, Value: true}},
+			TagPairs:   []string{"error=true"},
+			References: []Reference{{RefType: ChildOf, TraceID: "1", SpanID: "1"}},
+			Logs:       []Log{{Timestamp: 1001, Fields: []KeyValue{{Key: "event", Type: StringType, Value: "retry"}}}},
+		}
+	}
+
+	stored := newSpan()
+	MergeSpan(stored, newSpan())
+
+	if !reflect.DeepEqual(stored, newSpan()) {
+		t.Errorf("merged span %+v, want it unchanged", stored)
+	}
+}
+
+func keyValueKeys(kvs []KeyValue) []string {
+	keys := make([]string, len(kvs))
+	for i, kv := range kvs {
+		keys[i] = kv.Key
+	}
+	return keys
+}
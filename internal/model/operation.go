@@ -1,5 +1,7 @@
 package model
 
+import "github.com/nicolastakashi/jaeger-redisearch/internal/redis"
+
 type Operation struct {
 	Key           string `json:"key" redis:",key"` // the redis:",key" is required to indicate which field is the ULID key
 	Ver           int64  `json:"ver" redis:",ver"` // the redis:",ver" is required to do optimistic locking to prevent lost update
@@ -7,4 +9,16 @@ type Operation struct {
 	OperationName string `json:"operation"`
 	SpanKind      string `json:"span_kind"`
 	Hash          string `json:"hash"`
+	RawNames      bool   `json:"rawNames,omitempty"` // names are stored as is, earlier versions escaped them
+}
+
+// UnescapeNames reverts the escaping of the names of documents written by earlier versions.
+func (o *Operation) UnescapeNames() {
+	if o.RawNames {
+		return
+	}
+
+	o.ServiceName = redis.UnTokenization(o.ServiceName)
+	o.OperationName = redis.UnTokenization(o.OperationName)
+	o.RawNames = true
 }
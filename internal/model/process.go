@@ -0,0 +1,12 @@
+package model
+
+// ProcessRecord is a process stored once and referenced by the spans it emitted through their ProcessHash.
+type ProcessRecord struct {
+	Hash        string     `json:"hash,omitempty" redis:",key"` // the redis:",key" is required to index the records, which are written as is, without it
+	Ver         int64      `json:"ver,omitempty" redis:",ver"`
+	ServiceName string     `json:"serviceName"`
+	Tags        []KeyValue `json:"tags,omitempty"`
+	TagPairs    []string   `json:"tagPairs,omitempty"` // key=value pair of every tag, matched by tag searches in place of the spans referencing the record
+	KeyID       string     `json:"keyID,omitempty"`    // id of the key the payload is encrypted with
+	Payload     string     `json:"payload,omitempty"`  // tags, encrypted when encryption at rest is enabled
+}
@@ -1,5 +1,12 @@
 package model
 
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/redis"
+)
+
 // ReferenceType is the reference type of one span to another
 type ReferenceType string
 
@@ -32,20 +39,40 @@ const (
 
 // Span is MongoDB representation of the domain span.
 type Span struct {
-	Key           string      `json:"key" redis:",key"` // the redis:",key" is required to indicate which field is the ULID key
+	Key           string      `json:"key" redis:",key"` // the redis:",key" is required to indicate which field is the document id, derived from the span identity
 	Ver           int64       `json:"ver" redis:",ver"` // the redis:",ver" is required to do optimistic locking to prevent lost update
 	TraceID       string      `json:"traceID"`
 	SpanID        string      `json:"spanID"`
 	OperationName string      `json:"operationName"`
-	StartTime     uint64      `json:"startTime"` // microseconds since Unix epoch
-	Duration      uint64      `json:"duration"`  // microseconds
-	References    []Reference `json:"references"`
-	ProcessID     string      `json:"processID"`
+	CatalogName   string      `json:"catalogName"` // name the operation is registered under in the catalog, normalized from OperationName
+	StartTime     uint64      `json:"startTime"`   // microseconds since Unix epoch
+	Duration      uint64      `json:"duration"`    // microseconds
+	Flags         uint32      `json:"flags"`
+	References    []Reference `json:"references,omitempty"`
+	ProcessID     string      `json:"processID,omitempty"`
+	ProcessHash   string      `json:"processHash,omitempty"` // hash of the process record holding the process tags, when the process is interned
 	Process       Process     `json:"process,omitempty"`
-	Tags          []KeyValue  `json:"tags"`
-	MultipleTags  []KeyValue  `json:"mTags"`
-	Logs          []Log       `json:"logs"`
-	Warnings      []string    `json:"warnings"`
+	Tags          []KeyValue  `json:"tags,omitempty"`
+	MultipleTags  []KeyValue  `json:"mTags,omitempty"`    // merged tags of earlier versions, only read to migrate the documents
+	TagPairs      []string    `json:"tagPairs,omitempty"` // key=value pair of every tag, process tag and log field, matched by tag searches
+	Logs          []Log       `json:"logs,omitempty"`
+	Warnings      []string    `json:"warnings,omitempty"`
+	KeyID         string      `json:"keyID,omitempty"`    // id of the key the payload is encrypted with
+	Payload       string      `json:"payload,omitempty"`  // tags, process tags, logs and warnings, encrypted when encryption at rest is enabled
+	Blob          string      `json:"blob,omitempty"`     // whole span, protobuf encoded and compressed, when stored in compact mode
+	RawNames      bool        `json:"rawNames,omitempty"` // names are stored as is, earlier versions escaped them
+}
+
+// UnescapeNames reverts the escaping of the operation and service names of documents written by earlier versions.
+func (s *Span) UnescapeNames() {
+	if s.RawNames {
+		return
+	}
+
+	s.OperationName = redis.UnTokenization(s.OperationName)
+	s.CatalogName = redis.UnTokenization(s.CatalogName)
+	s.Process.ServiceName = redis.UnTokenization(s.Process.ServiceName)
+	s.RawNames = true
 }
 
 type Reference struct {
@@ -56,12 +83,12 @@ type Reference struct {
 
 type Process struct {
 	ServiceName string     `json:"serviceName"`
-	Tags        []KeyValue `json:"tags"`
+	Tags        []KeyValue `json:"tags,omitempty"`
 }
 
 type Log struct {
-	Timestamp uint64     `bson:"timestamp"`
-	Fields    []KeyValue `bson:"fields"`
+	Timestamp uint64     `json:"timestamp"`
+	Fields    []KeyValue `json:"fields"`
 }
 
 type KeyValue struct {
@@ -69,3 +96,12 @@ type KeyValue struct {
 	Type  ValueType   `json:"type,omitempty"`
 	Value interface{} `json:"value"`
 }
+
+// UnmarshalJSON decodes numbers as json.Number, so int64 values beyond the precision of float64 are read back exactly.
+func (kv *KeyValue) UnmarshalJSON(data []byte) error {
+	type keyValue KeyValue
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode((*keyValue)(kv))
+}
@@ -1,18 +1,101 @@
 package model
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
-	"github.com/nicolastakashi/jaeger-redisearch/internal/redis"
-
 	jModel "github.com/jaegertracing/jaeger/model"
 )
 
+// ConvertSpanFromJaeger converts the span to its document, without the document key and catalog name.
+func ConvertSpanFromJaeger(jSpan *jModel.Span) *Span {
+	return &Span{
+		TraceID:       jSpan.TraceID.String(),
+		SpanID:        jSpan.SpanID.String(),
+		OperationName: jSpan.OperationName,
+		Flags:         uint32(jSpan.Flags),
+		StartTime:     jModel.TimeAsEpochMicroseconds(jSpan.StartTime),
+		Duration:      jModel.DurationAsMicroseconds(jSpan.Duration),
+		References:    ConvertReferencesFromJaeger(jSpan),
+		ProcessID:     jSpan.ProcessID,
+		Process:       ConvertProcessFromJager(jSpan.Process),
+		Tags:          ConvertKeyValuesFromJaeger(jSpan.Tags),
+		TagPairs:      TagPairs(jSpan),
+		Logs:          ConvertLogFromJaeger(jSpan.Logs),
+		Warnings:      jSpan.Warnings,
+		RawNames:      true,
+	}
+}
+
+// ConvertSpanToJaeger converts the document back to the span it was converted from, unescaping the names of earlier documents.
+// Values that cannot be converted are kept as strings and references that cannot be converted are skipped,
+// so the span is always returned, along with an error listing what could not be converted.
+func ConvertSpanToJaeger(span *Span) (*jModel.Span, error) {
+	span.UnescapeNames()
+	errs := []string{}
+
+	traceID, err := jModel.TraceIDFromString(span.TraceID)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("trace id: %v", err))
+	}
+
+	spanID, err := jModel.SpanIDFromString(span.SpanID)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("span id: %v", err))
+	}
+
+	refs, err := ConvertReferencesToJaeger(span.References)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("references: %v", err))
+	}
+
+	tags, err := ConvertKeyValuesToJaeger(span.Tags)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("tags: %v", err))
+	}
+
+	processTags, err := ConvertKeyValuesToJaeger(span.Process.Tags)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("process tags: %v", err))
+	}
+
+	logs, err := ConvertLogToJaeger(span.Logs)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("logs: %v", err))
+	}
+
+	jSpan := &jModel.Span{
+		TraceID:       traceID,
+		SpanID:        spanID,
+		OperationName: span.OperationName,
+		References:    refs,
+		Flags:         jModel.Flags(span.Flags),
+		StartTime:     jModel.EpochMicrosecondsAsTime(span.StartTime),
+		Duration:      jModel.MicrosecondsAsDuration(span.Duration),
+		Tags:          tags,
+		Logs:          logs,
+		ProcessID:     span.ProcessID,
+		Process: &jModel.Process{
+			ServiceName: span.Process.ServiceName,
+			Tags:        processTags,
+		},
+		Warnings: span.Warnings,
+	}
+
+	if len(errs) > 0 {
+		return jSpan, errors.New(strings.Join(errs, "; "))
+	}
+	return jSpan, nil
+}
+
 func ConvertProcessFromJager(process *jModel.Process) Process {
 	return Process{
-		ServiceName: redis.Tokenization(process.ServiceName),
+		ServiceName: process.ServiceName,
 		Tags:        ConvertKeyValuesFromJaeger(process.Tags),
 	}
 }
@@ -44,12 +127,40 @@ func ConvertKeyValuesFromJaeger(keyValues jModel.KeyValues) []KeyValue {
 	return kvs
 }
 
+// ConvertKeyValueFromJaeger converts the tag keeping the type of its value: strings, booleans and numbers are stored as such,
+// binary values are base64 encoded and the float values JSON cannot represent (NaN and infinities) are stored as strings.
 func ConvertKeyValueFromJaeger(jKv jModel.KeyValue) KeyValue {
 	kv := KeyValue{
 		Key:  jKv.Key,
 		Type: ValueType(strings.ToLower(jKv.VType.String())),
 	}
 
+	switch jKv.VType {
+	case jModel.BoolType:
+		kv.Value = jKv.Bool()
+	case jModel.Int64Type:
+		kv.Value = jKv.Int64()
+	case jModel.Float64Type:
+		if math.IsNaN(jKv.Float64()) || math.IsInf(jKv.Float64(), 0) {
+			kv.Value = strconv.FormatFloat(jKv.Float64(), 'g', -1, 64)
+		} else {
+			kv.Value = jKv.Float64()
+		}
+	case jModel.BinaryType:
+		kv.Value = base64.StdEncoding.EncodeToString(jKv.Binary())
+	default:
+		kv.Value = jKv.VStr
+	}
+	return kv
+}
+
+// convertSearchableKeyValueFromJaeger converts the tag with its value as a string, the only type TAG fields can index.
+func convertSearchableKeyValueFromJaeger(jKv jModel.KeyValue) KeyValue {
+	kv := KeyValue{
+		Key:  jKv.Key,
+		Type: ValueType(strings.ToLower(jKv.VType.String())),
+	}
+
 	if jKv.VType == jModel.BinaryType {
 		kv.Value = string(jKv.Binary())
 		return kv
@@ -70,124 +181,205 @@ func ConvertLogFromJaeger(jLogs []jModel.Log) []Log {
 	return logs
 }
 
-func MergeTags(jSpan *jModel.Span) []KeyValue {
-	visitedTags := map[string]bool{}
-	mTags := []KeyValue{}
-
-	for _, t := range jSpan.Tags {
-		if _, ok := visitedTags[t.Key]; !ok {
-			visitedTags[t.Key] = true
-			mTags = append(mTags, ConvertKeyValueFromJaeger(t))
-		}
+// TagPairs returns the key=value pair of every tag, process tag and log field of the span, each pair once.
+// Pairs are indexed as single values, so a search for a tag only matches spans holding the key with that value.
+func TagPairs(jSpan *jModel.Span) []string {
+	kvs := [][]jModel.KeyValue{jSpan.Tags, jSpan.Process.Tags}
+	for _, l := range jSpan.Logs {
+		kvs = append(kvs, l.Fields)
 	}
+	return KeyValuePairs(kvs...)
+}
 
-	for _, t := range jSpan.Process.Tags {
-		if _, ok := visitedTags[t.Key]; !ok {
-			visitedTags[t.Key] = true
-			mTags = append(mTags, ConvertKeyValueFromJaeger(t))
-		}
-	}
+// KeyValuePairs returns the key=value pair of every key value, each pair once.
+func KeyValuePairs(kvs ...[]jModel.KeyValue) []string {
+	visited := map[string]bool{}
+	pairs := []string{}
 
-	for _, l := range jSpan.Logs {
-		for _, t := range l.Fields {
-			if _, ok := visitedTags[t.Key]; !ok {
-				visitedTags[t.Key] = true
-				mTags = append(mTags, ConvertKeyValueFromJaeger(t))
+	for _, kv := range kvs {
+		for _, t := range kv {
+			pair := TagPair(t.Key, convertSearchableKeyValueFromJaeger(t).Value.(string))
+			if !visited[pair] {
+				visited[pair] = true
+				pairs = append(pairs, pair)
 			}
 		}
 	}
 
-	return mTags
+	return pairs
 }
 
-func ConvertReferencesToJaeger(refs []Reference) ([]jModel.SpanRef, error) {
-	retMe := make([]jModel.SpanRef, len(refs))
-	for i, r := range refs {
-		// There are some inconsistencies with ReferenceTypes, hence the hacky fix.
-		var refType jModel.SpanRefType
-		switch r.RefType {
-		case ChildOf:
-			refType = jModel.ChildOf
-		case FollowsFrom:
-			refType = jModel.FollowsFrom
-		default:
-			return nil, fmt.Errorf("not a valid SpanRefType string %s", string(r.RefType))
-		}
+// tagPairKeyEscaper escapes the separator of the pair in keys, and the escape character itself,
+// so the first unescaped "=" always ends the key and different tags never share a pair.
+var tagPairKeyEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`)
 
-		traceID, err := jModel.TraceIDFromString(string(r.TraceID))
-		if err != nil {
-			return nil, err
-		}
+// TagPair returns the pair searched for the tag.
+func TagPair(key string, value string) string {
+	return tagPairKeyEscaper.Replace(key) + "=" + value
+}
 
-		spanID, err := jModel.SpanIDFromString(string(r.SpanID))
+// ConvertReferencesToJaeger converts the references, skipping the ones that are not valid and returning an error listing them.
+func ConvertReferencesToJaeger(refs []Reference) ([]jModel.SpanRef, error) {
+	retMe := make([]jModel.SpanRef, 0, len(refs))
+	errs := []string{}
+	for _, r := range refs {
+		ref, err := convertReferenceToJaeger(r)
 		if err != nil {
-			return nil, err
+			errs = append(errs, err.Error())
+			continue
 		}
+		retMe = append(retMe, ref)
+	}
 
-		retMe[i] = jModel.SpanRef{
-			RefType: refType,
-			TraceID: traceID,
-			SpanID:  spanID,
-		}
+	if len(errs) > 0 {
+		return retMe, errors.New(strings.Join(errs, "; "))
 	}
 	return retMe, nil
 }
 
+func convertReferenceToJaeger(r Reference) (jModel.SpanRef, error) {
+	// There are some inconsistencies with ReferenceTypes, hence the hacky fix.
+	var refType jModel.SpanRefType
+	switch r.RefType {
+	case ChildOf:
+		refType = jModel.ChildOf
+	case FollowsFrom:
+		refType = jModel.FollowsFrom
+	default:
+		return jModel.SpanRef{}, fmt.Errorf("not a valid SpanRefType string %s", string(r.RefType))
+	}
+
+	traceID, err := jModel.TraceIDFromString(string(r.TraceID))
+	if err != nil {
+		return jModel.SpanRef{}, err
+	}
+
+	spanID, err := jModel.SpanIDFromString(string(r.SpanID))
+	if err != nil {
+		return jModel.SpanRef{}, err
+	}
+
+	return jModel.SpanRef{
+		RefType: refType,
+		TraceID: traceID,
+		SpanID:  spanID,
+	}, nil
+}
+
+// ConvertKeyValuesToJaeger converts the tags. Tags whose value does not match their type are kept as string tags,
+// and an error listing them is returned.
 func ConvertKeyValuesToJaeger(tags []KeyValue) ([]jModel.KeyValue, error) {
 	retMe := make([]jModel.KeyValue, len(tags))
+	errs := []string{}
 	for i := range tags {
 		kv, err := convertKeyValueToJaeger(&tags[i])
 		if err != nil {
-			return nil, err
+			errs = append(errs, err.Error())
+			kv = jModel.String(tags[i].Key, fmt.Sprint(tags[i].Value))
 		}
 		retMe[i] = kv
 	}
+
+	if len(errs) > 0 {
+		return retMe, errors.New(strings.Join(errs, "; "))
+	}
 	return retMe, nil
 }
 
+// convertKeyValueToJaeger converts the tag, whose value is either stored with its type
+// or, for documents written by earlier versions, as a string.
 func convertKeyValueToJaeger(tag *KeyValue) (jModel.KeyValue, error) {
 	if tag.Value == nil {
 		return jModel.KeyValue{}, fmt.Errorf("invalid nil Value in %v", tag)
 	}
-	tagValue, ok := tag.Value.(string)
-	if !ok {
-		return jModel.KeyValue{}, fmt.Errorf("non-string Value of type %t in %v", tag.Value, tag)
-	}
+
 	switch tag.Type {
 	case StringType:
-		return jModel.String(tag.Key, tagValue), nil
+		value, ok := tag.Value.(string)
+		if !ok {
+			return jModel.KeyValue{}, fmt.Errorf("non-string Value of type %T in %v", tag.Value, tag)
+		}
+		return jModel.String(tag.Key, value), nil
 	case BoolType:
-		value, err := strconv.ParseBool(tagValue)
-		if err != nil {
-			return jModel.KeyValue{}, err
+		switch value := tag.Value.(type) {
+		case bool:
+			return jModel.Bool(tag.Key, value), nil
+		case string:
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return jModel.KeyValue{}, err
+			}
+			return jModel.Bool(tag.Key, parsed), nil
 		}
-		return jModel.Bool(tag.Key, value), nil
 	case Int64Type:
-		value, err := strconv.ParseInt(tagValue, 10, 64)
-		if err != nil {
-			return jModel.KeyValue{}, err
+		switch value := tag.Value.(type) {
+		case int64:
+			return jModel.Int64(tag.Key, value), nil
+		case json.Number:
+			parsed, err := value.Int64()
+			if err != nil {
+				return jModel.KeyValue{}, err
+			}
+			return jModel.Int64(tag.Key, parsed), nil
+		case string:
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return jModel.KeyValue{}, err
+			}
+			return jModel.Int64(tag.Key, parsed), nil
 		}
-		return jModel.Int64(tag.Key, value), nil
 	case Float64Type:
-		value, err := strconv.ParseFloat(tagValue, 64)
-		if err != nil {
-			return jModel.KeyValue{}, err
+		switch value := tag.Value.(type) {
+		case float64:
+			return jModel.Float64(tag.Key, value), nil
+		case json.Number:
+			parsed, err := value.Float64()
+			if err != nil {
+				return jModel.KeyValue{}, err
+			}
+			return jModel.Float64(tag.Key, parsed), nil
+		case string:
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return jModel.KeyValue{}, err
+			}
+			return jModel.Float64(tag.Key, parsed), nil
 		}
-		return jModel.Float64(tag.Key, value), nil
 	case BinaryType:
-		return jModel.Binary(tag.Key, []byte(tagValue)), nil
+		value, ok := tag.Value.(string)
+		if !ok {
+			return jModel.KeyValue{}, fmt.Errorf("non-string Value of type %T in %v", tag.Value, tag)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			// Earlier versions stored the raw bytes.
+			decoded = []byte(value)
+		}
+		return jModel.Binary(tag.Key, decoded), nil
+	default:
+		return jModel.KeyValue{}, fmt.Errorf("not a valid ValueType string %s", string(tag.Type))
 	}
-	return jModel.KeyValue{}, fmt.Errorf("not a valid ValueType string %s", string(tag.Type))
+
+	return jModel.KeyValue{}, fmt.Errorf("invalid Value of type %T for %s in %v", tag.Value, tag.Type, tag)
 }
 
-func ConvertLogToJaeger(logs []Log) []jModel.Log {
+// ConvertLogToJaeger converts the logs, the fields that cannot be converted being kept as string fields.
+func ConvertLogToJaeger(logs []Log) ([]jModel.Log, error) {
 	jLogs := make([]jModel.Log, 0)
+	errs := []string{}
 	for _, log := range logs {
-		fields, _ := ConvertKeyValuesToJaeger(log.Fields)
+		fields, err := ConvertKeyValuesToJaeger(log.Fields)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
 		jLogs = append(jLogs, jModel.Log{
 			Timestamp: jModel.EpochMicrosecondsAsTime(log.Timestamp),
 			Fields:    fields,
 		})
 	}
-	return jLogs
+
+	if len(errs) > 0 {
+		return jLogs, errors.New(strings.Join(errs, "; "))
+	}
+	return jLogs, nil
 }
@@ -0,0 +1,316 @@
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+	"unicode/utf8"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+// TestSpanRoundTrip checks every field of random spans survives the conversion to a document,
+// its JSON encoding as stored in Redis, and the conversion back.
+func TestSpanRoundTrip(t *testing.T) {
+	roundTrip := func(s randomSpan) bool {
+		expected := s.Span
+
+		data, err := json.Marshal(ConvertSpanFromJaeger(expected))
+		if err != nil {
+			t.Logf("marshal: %v", err)
+			return false
+		}
+
+		stored := &Span{}
+		if err := json.Unmarshal(data, stored); err != nil {
+			t.Logf("unmarshal: %v", err)
+			return false
+		}
+
+		actual, err := ConvertSpanToJaeger(stored)
+		if err != nil {
+			t.Logf("convert: %v", err)
+			return false
+		}
+
+		if !reflect.DeepEqual(normalizeSpan(expected), normalizeSpan(actual)) {
+			t.Logf("expected %v\ngot %v", expected, actual)
+			return false
+		}
+		return true
+	}
+
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 500}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSpanReadsStringValues checks documents written with every tag value stored as a string are still read with their types.
+func TestSpanReadsStringValues(t *testing.T) {
+	stored := &Span{}
+	err := json.Unmarshal([]byte(`{
+		"traceID": "0000000000000001",
+		"spanID": "0000000000000002",
+		"tags": [
+			{"key": "b", "type": "bool", "value": "true"},
+			{"key": "i", "type": "int64", "value": "9007199254740993"},
+			{"key": "f", "type": "float64", "value": "1.5"},
+			{"key": "bin", "type": "binary", "value": "raw"}
+		]
+	}`), stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	span, err := ConvertSpanToJaeger(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := jModel.KeyValues{
+		jModel.Bool("b", true),
+		jModel.Int64("i", 9007199254740993),
+		jModel.Float64("f", 1.5),
+		jModel.Binary("bin", []byte("raw")),
+	}
+	if !reflect.DeepEqual(jModel.KeyValues(span.Tags), expected) {
+		t.Fatalf("expected %v, got %v", expected, span.Tags)
+	}
+}
+
+// TestSpanKeepsInvalidValues checks a tag whose value does not match its type is returned as a string along with an error.
+func TestSpanKeepsInvalidValues(t *testing.T) {
+	stored := &Span{
+		TraceID: "0000000000000001",
+		SpanID:  "0000000000000002",
+		Tags: []KeyValue{
+			{Key: "ok", Type: StringType, Value: "value"},
+			{Key: "broken", Type: Int64Type, Value: "not a number"},
+		},
+	}
+
+	span, err := ConvertSpanToJaeger(stored)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	expected := jModel.KeyValues{jModel.String("ok", "value"), jModel.String("broken", "not a number")}
+	if !reflect.DeepEqual(jModel.KeyValues(span.Tags), expected) {
+		t.Fatalf("expected %v, got %v", expected, span.Tags)
+	}
+}
+
+// FuzzSpanNames checks any service, operation and tag is stored as is, the tag pairs searches match holding the raw tag,
+// and read back unchanged.
+func FuzzSpanNames(f *testing.F) {
+	f.Add("frontend", "HTTP GET /customer", "http.url", "/customer?id=1")
+	f.Add("a\\-b", "{op}|\"x\"", "key with spaces", "C:\\path")
+
+	f.Fuzz(func(t *testing.T, service string, operation string, key string, value string) {
+		for _, s := range []string{service, operation, key, value} {
+			if !utf8.ValidString(s) {
+				t.Skip("stored values are valid UTF-8")
+			}
+		}
+
+		jSpan := &jModel.Span{
+			TraceID:       jModel.NewTraceID(1, 2),
+			SpanID:        jModel.NewSpanID(3),
+			OperationName: operation,
+			Process:       jModel.NewProcess(service, nil),
+			Tags:          jModel.KeyValues{jModel.String(key, value)},
+		}
+
+		data, err := json.Marshal(ConvertSpanFromJaeger(jSpan))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stored := &Span{}
+		if err := json.Unmarshal(data, stored); err != nil {
+			t.Fatal(err)
+		}
+
+		if stored.Process.ServiceName != service || stored.OperationName != operation {
+			t.Fatalf("stored %q %q, want %q %q", stored.Process.ServiceName, stored.OperationName, service, operation)
+		}
+		if len(stored.TagPairs) != 1 || stored.TagPairs[0] != TagPair(key, value) {
+			t.Fatalf("stored tag pairs %q, want %q=%q", stored.TagPairs, key, value)
+		}
+
+		actual, err := ConvertSpanToJaeger(stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual.Process.ServiceName != service || actual.OperationName != operation || !reflect.DeepEqual(actual.Tags, []jModel.KeyValue{jModel.String(key, value)}) {
+			t.Fatalf("read back %q %q %v", actual.Process.ServiceName, actual.OperationName, actual.Tags)
+		}
+	})
+}
+
+// TestSpanUnescapesEarlierNames checks the names earlier versions stored escaped are read back unescaped.
+func TestSpanUnescapesEarlierNames(t *testing.T) {
+	stored := &Span{
+		TraceID:       "0000000000000001",
+		SpanID:        "0000000000000002",
+		OperationName: "users\\.list\\(\\)",
+		Process:       Process{ServiceName: "front\\-end"},
+	}
+
+	span, _ := ConvertSpanToJaeger(stored)
+	if span.OperationName != "users.list()" || span.Process.ServiceName != "front-end" {
+		t.Fatalf("read back %q %q", span.OperationName, span.Process.ServiceName)
+	}
+}
+
+type randomSpan struct {
+	*jModel.Span
+}
+
+func (randomSpan) Generate(r *rand.Rand, size int) reflect.Value {
+	span := &jModel.Span{
+		TraceID:       jModel.NewTraceID(r.Uint64(), r.Uint64()),
+		SpanID:        jModel.NewSpanID(r.Uint64()),
+		OperationName: randomString(r, size),
+		Flags:         jModel.Flags(r.Uint32()),
+		StartTime:     jModel.EpochMicrosecondsAsTime(uint64(r.Int63n(4e15))),
+		Duration:      time.Duration(r.Int63n(1e12)) * time.Microsecond,
+		Tags:          randomKeyValues(r, size),
+		ProcessID:     randomString(r, size),
+		Process: &jModel.Process{
+			ServiceName: randomString(r, size),
+			Tags:        randomKeyValues(r, size),
+		},
+	}
+
+	for i := r.Intn(4); i > 0; i-- {
+		refType := jModel.ChildOf
+		if r.Intn(2) == 0 {
+			refType = jModel.FollowsFrom
+		}
+		span.References = append(span.References, jModel.SpanRef{
+			TraceID: jModel.NewTraceID(r.Uint64(), r.Uint64()),
+			SpanID:  jModel.NewSpanID(r.Uint64()),
+			RefType: refType,
+		})
+	}
+
+	for i := r.Intn(4); i > 0; i-- {
+		span.Logs = append(span.Logs, jModel.Log{
+			Timestamp: jModel.EpochMicrosecondsAsTime(uint64(r.Int63n(4e15))),
+			Fields:    randomKeyValues(r, size),
+		})
+	}
+
+	for i := r.Intn(3); i > 0; i-- {
+		span.Warnings = append(span.Warnings, randomString(r, size))
+	}
+
+	return reflect.ValueOf(randomSpan{span})
+}
+
+func randomKeyValues(r *rand.Rand, size int) []jModel.KeyValue {
+	kvs := []jModel.KeyValue{}
+	for i := r.Intn(6); i > 0; i-- {
+		key := randomString(r, size)
+		switch r.Intn(5) {
+		case 0:
+			kvs = append(kvs, jModel.String(key, randomString(r, size)))
+		case 1:
+			kvs = append(kvs, jModel.Bool(key, r.Intn(2) == 0))
+		case 2:
+			kvs = append(kvs, jModel.Int64(key, int64(r.Uint64())))
+		case 3:
+			values := []float64{r.NormFloat64() * math.MaxFloat64, r.Float64(), math.NaN(), math.Inf(1), math.Inf(-1), 0}
+			kvs = append(kvs, jModel.Float64(key, values[r.Intn(len(values))]))
+		default:
+			value := make([]byte, r.Intn(size+1))
+			r.Read(value)
+			kvs = append(kvs, jModel.Binary(key, value))
+		}
+	}
+	return kvs
+}
+
+// randomString returns a string of random runes, including the characters escaped in queries.
+func randomString(r *rand.Rand, size int) string {
+	runes := []rune(",.<>{}[]\"':;!@#$%^&*()-+=~ /?|\\_aZ09é日本")
+	var b strings.Builder
+	for i := r.Intn(size + 1); i > 0; i-- {
+		if r.Intn(4) == 0 {
+			b.WriteRune(rune(r.Intn(0xD7FF-0x20) + 0x20))
+			continue
+		}
+		b.WriteRune(runes[r.Intn(len(runes))])
+	}
+	return b.String()
+}
+
+// normalizeSpan turns empty slices into nil slices, the conversion not preserving the difference,
+// and NaN values into strings, as NaN is not equal to itself.
+func normalizeSpan(span *jModel.Span) *jModel.Span {
+	normalized := *span
+	process := *span.Process
+	normalized.Process = &process
+
+	if len(normalized.References) == 0 {
+		normalized.References = nil
+	}
+	normalized.Tags = normalizeKeyValues(normalized.Tags)
+	normalized.Process.Tags = normalizeKeyValues(normalized.Process.Tags)
+	if len(normalized.Warnings) == 0 {
+		normalized.Warnings = nil
+	}
+	if len(normalized.Logs) == 0 {
+		normalized.Logs = nil
+	}
+
+	normalized.Logs = append([]jModel.Log(nil), normalized.Logs...)
+	for i := range normalized.Logs {
+		normalized.Logs[i].Fields = normalizeKeyValues(normalized.Logs[i].Fields)
+	}
+	return &normalized
+}
+
+func normalizeKeyValues(kvs []jModel.KeyValue) []jModel.KeyValue {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	normalized := make([]jModel.KeyValue, len(kvs))
+	for i, kv := range kvs {
+		if kv.VType == jModel.Float64Type && math.IsNaN(kv.Float64()) {
+			kv = jModel.String(kv.Key, "NaN")
+		}
+		normalized[i] = kv
+	}
+	return normalized
+}
+
+// TestTagPairSeparatesKeyAndValue checks tags whose key and value only differ by where "=" splits them have different pairs.
+func TestTagPairSeparatesKeyAndValue(t *testing.T) {
+	tests := []struct {
+		key, value      string
+		otherKey        string
+		otherValue      string
+		pair, otherPair string
+	}{
+		{key: "a=b", value: "c", otherKey: "a", otherValue: "b=c", pair: `a\=b=c`, otherPair: "a=b=c"},
+		{key: `a\`, value: "=b", otherKey: `a\=`, otherValue: "b", pair: `a\\==b`, otherPair: `a\\\==b`},
+	}
+
+	for _, tt := range tests {
+		pair, otherPair := TagPair(tt.key, tt.value), TagPair(tt.otherKey, tt.otherValue)
+		if pair != tt.pair || otherPair != tt.otherPair {
+			t.Errorf("pairs %q and %q, want %q and %q", pair, otherPair, tt.pair, tt.otherPair)
+		}
+		if pair == otherPair {
+			t.Errorf("tags %q=%q and %q=%q share the pair %q", tt.key, tt.value, tt.otherKey, tt.otherValue, pair)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package otlp
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+	jaegertranslator "github.com/open-telemetry/opentelemetry-collector-contrib/pkg/translator/jaeger"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"google.golang.org/grpc"
+	// Registers the gzip compressor, OTLP exporters compress their gRPC requests with it by default.
+	_ "google.golang.org/grpc/encoding/gzip"
+)
+
+const (
+	tracesPath          = "/v1/traces"
+	protobufContentType = "application/x-protobuf"
+	jsonContentType     = "application/json"
+	gzipEncoding        = "gzip"
+)
+
+// SpanWriter writes the spans of a request at once, returning the result of each span.
+type SpanWriter interface {
+	WriteSpans(ctx context.Context, spans []*jModel.Span) []error
+}
+
+// Receiver accepts spans sent with the OpenTelemetry protocol, over gRPC and HTTP,
+// and writes them through a span writer as if they were received from a Jaeger collector.
+type Receiver struct {
+	logger       hclog.Logger
+	writer       SpanWriter
+	grpcEndpoint string
+	httpEndpoint string
+	maxSize      int64
+	grpcServer   *grpc.Server
+	httpServer   *http.Server
+}
+
+func NewReceiver(logger hclog.Logger, writer SpanWriter, config model.Configuration) *Receiver {
+	return &Receiver{
+		logger:       logger,
+		writer:       writer,
+		grpcEndpoint: config.OTLPGrpcEndpoint,
+		httpEndpoint: config.OTLPHttpEndpoint,
+		maxSize:      config.OTLPMaxRequestSize,
+	}
+}
+
+// Start listens on the configured endpoints, an empty endpoint disables its protocol.
+func (r *Receiver) Start() error {
+	if r.grpcEndpoint != "" {
+		listener, err := net.Listen("tcp", r.grpcEndpoint)
+		if err != nil {
+			return err
+		}
+
+		r.grpcServer = grpc.NewServer(grpc.MaxRecvMsgSize(int(r.maxSize)))
+		ptraceotlp.RegisterServer(r.grpcServer, &grpcHandler{receiver: r})
+
+		go func() {
+			if err := r.grpcServer.Serve(listener); err != nil {
+				r.logger.Error("failed to serve OTLP over gRPC", "err", err)
+			}
+		}()
+	}
+
+	if r.httpEndpoint != "" {
+		listener, err := net.Listen("tcp", r.httpEndpoint)
+		if err != nil {
+			r.Close()
+			return err
+		}
+
+		r.httpServer = &http.Server{Handler: r.httpHandler()}
+
+		go func() {
+			if err := r.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				r.logger.Error("failed to serve OTLP over HTTP", "err", err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Close stops accepting spans, once the requests in flight are answered.
+// It must be called before the span writer is closed.
+func (r *Receiver) Close() error {
+	if r.grpcServer != nil {
+		r.grpcServer.GracefulStop()
+	}
+
+	if r.httpServer != nil {
+		return r.httpServer.Shutdown(context.Background())
+	}
+
+	return nil
+}
+
+type grpcHandler struct {
+	receiver *Receiver
+}
+
+func (h *grpcHandler) Export(ctx context.Context, request ptraceotlp.Request) (ptraceotlp.Response, error) {
+	return ptraceotlp.NewResponse(), h.receiver.consume(ctx, request.Traces(), "grpc")
+}
+
+func (r *Receiver) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(tracesPath, r.handleHTTP)
+	return mux
+}
+
+func (r *Receiver) handleHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if contentType != protobufContentType && contentType != jsonContentType {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := r.readBody(w, req)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, errUnsupportedEncoding) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request := ptraceotlp.NewRequest()
+	if contentType == jsonContentType {
+		err = request.UnmarshalJSON(body)
+	} else {
+		err = request.UnmarshalProto(body)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.consume(req.Context(), request.Traces(), "http"); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	response := ptraceotlp.NewResponse()
+	var data []byte
+	if contentType == jsonContentType {
+		data, err = response.MarshalJSON()
+	} else {
+		data, err = response.MarshalProto()
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// readBody reads the body of the request, decompressing it when it is encoded with gzip.
+// The body is limited to the maximum request size, both as received and once decompressed.
+func (r *Receiver) readBody(w http.ResponseWriter, req *http.Request) ([]byte, error) {
+	body := http.MaxBytesReader(w, req.Body, r.maxSize)
+
+	switch req.Header.Get("Content-Encoding") {
+	case "", "identity":
+		return io.ReadAll(body)
+	case gzipEncoding:
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		data, err := io.ReadAll(io.LimitReader(reader, r.maxSize+1))
+		if err == nil && int64(len(data)) > r.maxSize {
+			err = &http.MaxBytesError{Limit: r.maxSize}
+		}
+		return data, err
+	default:
+		return nil, errUnsupportedEncoding
+	}
+}
+
+// consume converts the traces to the Jaeger model and writes their spans.
+// Resource attributes become process tags, scope name and version become the otel.library.* span tags
+// and the scope attributes are added to the tags of the spans of the scope.
+//
+// The spans of the request are written at once, so they share write batches. All spans are written even when
+// some fail, the first error is returned so the client retries the request, which is harmless since writing a span
+// twice stores it once.
+func (r *Receiver) consume(ctx context.Context, traces ptrace.Traces, transport string) error {
+	var firstErr error
+	spans := []*jModel.Span{}
+
+	resourceSpans := traces.ResourceSpans()
+	for i := 0; i < resourceSpans.Len(); i++ {
+		scopeSpans := resourceSpans.At(i).ScopeSpans()
+		for j := 0; j < scopeSpans.Len(); j++ {
+			// The translator drops the scope attributes, each scope is translated on its own so they can be added back.
+			scope := ptrace.NewTraces()
+			resource := scope.ResourceSpans().AppendEmpty()
+			resourceSpans.At(i).Resource().CopyTo(resource.Resource())
+			scopeSpans.At(j).CopyTo(resource.ScopeSpans().AppendEmpty())
+
+			batches, err := jaegertranslator.ProtoFromTraces(scope)
+			if err != nil {
+				metrics.ReceivedSpans.WithLabelValues(transport, "failed").Add(float64(scopeSpans.At(j).Spans().Len()))
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+
+			scopeTags := attributesToTags(scopeSpans.At(j).Scope().Attributes())
+			for _, batch := range batches {
+				for _, span := range batch.Spans {
+					span.Process = batch.Process
+					span.Tags = append(span.Tags, scopeTags...)
+					spans = append(spans, span)
+				}
+			}
+		}
+	}
+
+	for _, err := range r.writer.WriteSpans(ctx, spans) {
+		if err != nil {
+			metrics.ReceivedSpans.WithLabelValues(transport, "failed").Inc()
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		metrics.ReceivedSpans.WithLabelValues(transport, "accepted").Inc()
+	}
+
+	if firstErr != nil {
+		r.logger.Error("error to write spans received over OTLP", "transport", transport, "err", firstErr)
+	}
+
+	return firstErr
+}
+
+func attributesToTags(attributes pcommon.Map) []jModel.KeyValue {
+	tags := make([]jModel.KeyValue, 0, attributes.Len())
+	attributes.Range(func(key string, value pcommon.Value) bool {
+		switch value.Type() {
+		case pcommon.ValueTypeBool:
+			tags = append(tags, jModel.Bool(key, value.Bool()))
+		case pcommon.ValueTypeInt:
+			tags = append(tags, jModel.Int64(key, value.Int()))
+		case pcommon.ValueTypeDouble:
+			tags = append(tags, jModel.Float64(key, value.Double()))
+		case pcommon.ValueTypeBytes:
+			tags = append(tags, jModel.Binary(key, value.Bytes().AsRaw()))
+		default:
+			tags = append(tags, jModel.String(key, value.AsString()))
+		}
+		return true
+	})
+	return tags
+}
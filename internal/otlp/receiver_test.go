@@ -0,0 +1,183 @@
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+)
+
+// recorder records the spans written through the receiver. Each write waits for linger, as a batched write does.
+type recorder struct {
+	linger time.Duration
+	mu     sync.Mutex
+	spans  []*jModel.Span
+}
+
+func (r *recorder) WriteSpans(ctx context.Context, spans []*jModel.Span) []error {
+	time.Sleep(r.linger)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = append(r.spans, spans...)
+	return make([]error, len(spans))
+}
+
+func testRequest() ptraceotlp.Request {
+	return testRequestOf(1)
+}
+
+// testRequestOf returns a request holding n spans of a service.
+func testRequestOf(n int) ptraceotlp.Request {
+	traces := ptrace.NewTraces()
+	resource := traces.ResourceSpans().AppendEmpty()
+	resource.Resource().Attributes().PutString("service.name", "api")
+	resource.Resource().Attributes().PutString("host.name", "node-one")
+
+	scope := resource.ScopeSpans().AppendEmpty()
+	scope.Scope().SetName("http")
+	scope.Scope().Attributes().PutString("scope.tag", "value")
+
+	for i := 0; i < n; i++ {
+		span := scope.Spans().AppendEmpty()
+		span.SetTraceID(pcommon.TraceID([16]byte{14: byte(i >> 8), 15: byte(i + 1)}))
+		span.SetSpanID(pcommon.SpanID([8]byte{6: byte(i >> 8), 7: byte(i + 1)}))
+		span.SetName("GET /users")
+	}
+
+	return ptraceotlp.NewRequestFromTraces(traces)
+}
+
+func compress(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func hasTag(tags []jModel.KeyValue, key string, value string) bool {
+	for _, tag := range tags {
+		if tag.Key == key && tag.AsString() == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReceiverHTTP(t *testing.T) {
+	request := testRequest()
+	protobuf, err := request.MarshalProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, err := request.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := bytes.Repeat([]byte{'a'}, 2048)
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		encoding    string
+		body        []byte
+		status      int
+	}{
+		{name: "protobuf", contentType: protobufContentType, body: protobuf, status: http.StatusOK},
+		{name: "json", contentType: jsonContentType + "; charset=utf-8", body: json, status: http.StatusOK},
+		{name: "gzip protobuf", contentType: protobufContentType, encoding: gzipEncoding, body: compress(t, protobuf), status: http.StatusOK},
+		{name: "gzip json", contentType: jsonContentType, encoding: gzipEncoding, body: compress(t, json), status: http.StatusOK},
+		{name: "body over the maximum size", contentType: protobufContentType, body: large, status: http.StatusRequestEntityTooLarge},
+		{name: "body over the maximum size once decompressed", contentType: protobufContentType, encoding: gzipEncoding, body: compress(t, large), status: http.StatusRequestEntityTooLarge},
+		{name: "invalid gzip", contentType: protobufContentType, encoding: gzipEncoding, body: protobuf, status: http.StatusBadRequest},
+		{name: "unsupported encoding", contentType: protobufContentType, encoding: "br", body: protobuf, status: http.StatusUnsupportedMediaType},
+		{name: "unsupported content type", contentType: "text/plain", body: protobuf, status: http.StatusUnsupportedMediaType},
+		{name: "invalid protobuf", contentType: protobufContentType, body: []byte{0xff, 0xff}, status: http.StatusBadRequest},
+		{name: "method", method: http.MethodGet, contentType: protobufContentType, status: http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writer := &recorder{}
+			receiver := NewReceiver(hclog.NewNullLogger(), writer, model.Configuration{OTLPMaxRequestSize: 1024})
+
+			method := test.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, tracesPath, bytes.NewReader(test.body))
+			req.Header.Set("Content-Type", test.contentType)
+			if test.encoding != "" {
+				req.Header.Set("Content-Encoding", test.encoding)
+			}
+
+			resp := httptest.NewRecorder()
+			receiver.httpHandler().ServeHTTP(resp, req)
+			if resp.Code != test.status {
+				t.Fatalf("status %d, want %d: %s", resp.Code, test.status, resp.Body.String())
+			}
+			if test.status != http.StatusOK {
+				if len(writer.spans) != 0 {
+					t.Errorf("rejected request wrote %d spans", len(writer.spans))
+				}
+				return
+			}
+
+			if len(writer.spans) != 1 {
+				t.Fatalf("wrote %d spans, want 1", len(writer.spans))
+			}
+			span := writer.spans[0]
+			if span.OperationName != "GET /users" || span.Process.ServiceName != "api" {
+				t.Errorf("wrote span %s of service %s", span.OperationName, span.Process.ServiceName)
+			}
+			if !hasTag(span.Process.Tags, "host.name", "node-one") {
+				t.Errorf("resource attributes missing from process tags %v", span.Process.Tags)
+			}
+			if !hasTag(span.Tags, "scope.tag", "value") || !hasTag(span.Tags, "otel.library.name", "http") {
+				t.Errorf("scope missing from span tags %v", span.Tags)
+			}
+		})
+	}
+}
+
+// TestReceiverWritesARequestAtOnce checks the spans of a request are written together, so a request waits for
+// a single batch linger rather than one per span.
+func TestReceiverWritesARequestAtOnce(t *testing.T) {
+	const linger = 20 * time.Millisecond
+
+	writer := &recorder{linger: linger}
+	receiver := NewReceiver(hclog.NewNullLogger(), writer, model.Configuration{OTLPMaxRequestSize: 4 << 20})
+
+	start := time.Now()
+	if err := receiver.consume(context.Background(), testRequestOf(1000).Traces(), "http"); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed >= 2*linger {
+		t.Errorf("writing the request took %v, more than a batch linger of %v", elapsed, linger)
+	}
+	if len(writer.spans) != 1000 {
+		t.Errorf("wrote %d spans, want 1000", len(writer.spans))
+	}
+}
@@ -0,0 +1,204 @@
+package redaction
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	// ActionMask replaces the sensitive data with a fixed placeholder.
+	ActionMask = "mask"
+	// ActionHash replaces the sensitive data with its keyed HMAC, so it can still be looked up by its plain value.
+	ActionHash = "hash"
+
+	maskedValue = "[REDACTED]"
+)
+
+var errMissingKey = errors.New("redaction_hmac_key is required to hash values")
+
+// builtinDetectors are the detectors selected by name, validate filters out matches that are not really sensitive.
+var builtinDetectors = map[string]struct {
+	pattern  string
+	validate func(match string) bool
+}{
+	"email":        {pattern: `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`},
+	"credit-card":  {pattern: `\b(?:\d[ -]?){12,18}\d\b`, validate: luhn},
+	"bearer-token": {pattern: `(?i)bearer\s+[A-Za-z0-9\-._~+/]+=*`},
+	"jwt":          {pattern: `eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`},
+}
+
+// Redactor masks or hashes the sensitive data found in tags, process tags and log fields.
+// Rules redact the whole value of a key, detectors redact the parts of any string value they match.
+type Redactor struct {
+	key       []byte
+	rules     map[string]string
+	detectors []detector
+}
+
+type detector struct {
+	name     string
+	action   string
+	pattern  *regexp.Regexp
+	validate func(match string) bool
+}
+
+// New creates the redactor described by the configuration, it returns nil when nothing is to be redacted.
+func New(config model.Configuration) (*Redactor, error) {
+	if len(config.RedactionRules) == 0 && len(config.RedactionDetectors) == 0 {
+		return nil, nil
+	}
+
+	r := &Redactor{
+		key:   []byte(config.RedactionKey),
+		rules: make(map[string]string, len(config.RedactionRules)),
+	}
+
+	for _, rule := range config.RedactionRules {
+		if err := r.checkAction(rule.Action); err != nil {
+			return nil, fmt.Errorf("invalid redaction rule for %s: %w", rule.Key, err)
+		}
+		r.rules[rule.Key] = rule.Action
+	}
+
+	for _, d := range config.RedactionDetectors {
+		if err := r.checkAction(d.Action); err != nil {
+			return nil, fmt.Errorf("invalid redaction detector %s: %w", d.Name, err)
+		}
+
+		pattern := d.Pattern
+		var validate func(string) bool
+		if pattern == "" {
+			builtin, ok := builtinDetectors[d.Name]
+			if !ok {
+				return nil, fmt.Errorf("unknown redaction detector %s", d.Name)
+			}
+			pattern, validate = builtin.pattern, builtin.validate
+		}
+
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of redaction detector %s: %w", d.Name, err)
+		}
+
+		r.detectors = append(r.detectors, detector{name: d.Name, action: d.Action, pattern: compiled, validate: validate})
+	}
+
+	return r, nil
+}
+
+func (r *Redactor) checkAction(action string) error {
+	switch action {
+	case ActionMask:
+		return nil
+	case ActionHash:
+		if len(r.key) == 0 {
+			return errMissingKey
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid action %s", action)
+	}
+}
+
+// Redact redacts the tags, process tags and log fields of the span.
+// The process and the logs may be shared with other spans, so they are copied rather than changed in place.
+func (r *Redactor) Redact(span *jModel.Span) {
+	span.Tags = r.redactKeyValues(span.Tags)
+
+	if span.Process != nil {
+		process := *span.Process
+		process.Tags = r.redactKeyValues(process.Tags)
+		span.Process = &process
+	}
+
+	if len(span.Logs) > 0 {
+		logs := make([]jModel.Log, len(span.Logs))
+		for i, log := range span.Logs {
+			logs[i] = jModel.Log{Timestamp: log.Timestamp, Fields: r.redactKeyValues(log.Fields)}
+		}
+		span.Logs = logs
+	}
+}
+
+// Value redacts a plain value the way it is redacted when stored under key.
+// Looking up the redacted value finds the spans that held the plain one, as long as it was hashed.
+func (r *Redactor) Value(key string, value string) string {
+	if action, ok := r.rules[key]; ok {
+		return r.apply(action, value)
+	}
+	return r.detect(value, false)
+}
+
+func (r *Redactor) redactKeyValues(kvs []jModel.KeyValue) []jModel.KeyValue {
+	result := make([]jModel.KeyValue, len(kvs))
+	for i, kv := range kvs {
+		if action, ok := r.rules[kv.Key]; ok {
+			result[i] = jModel.String(kv.Key, r.apply(action, kv.AsString()))
+			metrics.RedactedValues.WithLabelValues(kv.Key, action).Inc()
+			continue
+		}
+
+		if kv.VType == jModel.StringType {
+			kv.VStr = r.detect(kv.VStr, true)
+		}
+		result[i] = kv
+	}
+	return result
+}
+
+// detect redacts the matches of the detectors in value, counting them when record is set.
+func (r *Redactor) detect(value string, record bool) string {
+	for _, d := range r.detectors {
+		value = d.pattern.ReplaceAllStringFunc(value, func(match string) string {
+			if d.validate != nil && !d.validate(match) {
+				return match
+			}
+			if record {
+				metrics.RedactedValues.WithLabelValues(d.name, d.action).Inc()
+			}
+			return r.apply(d.action, match)
+		})
+	}
+	return value
+}
+
+func (r *Redactor) apply(action string, value string) string {
+	if action == ActionMask {
+		return maskedValue
+	}
+
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// luhn checks the digits of a card number candidate against their check digit.
+func luhn(number string) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits > 0 && sum%10 == 0
+}
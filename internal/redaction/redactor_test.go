@@ -0,0 +1,159 @@
+package redaction
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+const testKey = "secret"
+
+func hashed(value string) string {
+	mac := hmac.New(sha256.New, []byte(testKey))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    model.Configuration
+		tag       jModel.KeyValue
+		wantValue string
+	}{
+		{
+			name:      "rule masks the value of its key",
+			config:    model.Configuration{RedactionRules: []model.RedactionRule{{Key: "password", Action: ActionMask}}},
+			tag:       jModel.String("password", "hunter2"),
+			wantValue: maskedValue,
+		},
+		{
+			name:      "rule hashes the value of its key",
+			config:    model.Configuration{RedactionKey: testKey, RedactionRules: []model.RedactionRule{{Key: "user.id", Action: ActionHash}}},
+			tag:       jModel.String("user.id", "42"),
+			wantValue: hashed("42"),
+		},
+		{
+			name:      "rule redacts values that are not strings",
+			config:    model.Configuration{RedactionKey: testKey, RedactionRules: []model.RedactionRule{{Key: "user.id", Action: ActionHash}}},
+			tag:       jModel.Int64("user.id", 42),
+			wantValue: hashed("42"),
+		},
+		{
+			name:      "rule leaves other keys",
+			config:    model.Configuration{RedactionRules: []model.RedactionRule{{Key: "password", Action: ActionMask}}},
+			tag:       jModel.String("user", "hunter2"),
+			wantValue: "hunter2",
+		},
+		{
+			name:      "detector masks the parts it matches",
+			config:    model.Configuration{RedactionDetectors: []model.RedactionDetector{{Name: "email", Action: ActionMask}}},
+			tag:       jModel.String("message", "mail jane@example.com now"),
+			wantValue: "mail " + maskedValue + " now",
+		},
+		{
+			name:      "detector hashes the parts it matches",
+			config:    model.Configuration{RedactionKey: testKey, RedactionDetectors: []model.RedactionDetector{{Name: "email", Action: ActionHash}}},
+			tag:       jModel.String("message", "jane@example.com"),
+			wantValue: hashed("jane@example.com"),
+		},
+		{
+			name:      "detector with a pattern",
+			config:    model.Configuration{RedactionDetectors: []model.RedactionDetector{{Name: "ssn", Pattern: `\d{3}-\d{2}-\d{4}`, Action: ActionMask}}},
+			tag:       jModel.String("note", "ssn 123-45-6789"),
+			wantValue: "ssn " + maskedValue,
+		},
+		{
+			name:      "card numbers passing the luhn check are redacted",
+			config:    model.Configuration{RedactionDetectors: []model.RedactionDetector{{Name: "credit-card", Action: ActionMask}}},
+			tag:       jModel.String("card", "4111 1111 1111 1111"),
+			wantValue: maskedValue,
+		},
+		{
+			name:      "card numbers failing the luhn check are kept",
+			config:    model.Configuration{RedactionDetectors: []model.RedactionDetector{{Name: "credit-card", Action: ActionMask}}},
+			tag:       jModel.String("order", "4111 1111 1111 1112"),
+			wantValue: "4111 1111 1111 1112",
+		},
+		{
+			name:      "detectors only apply to strings",
+			config:    model.Configuration{RedactionDetectors: []model.RedactionDetector{{Name: "digits", Pattern: `\d+`, Action: ActionMask}}},
+			tag:       jModel.Int64("count", 12),
+			wantValue: "12",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redactor, err := New(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			process := jModel.NewProcess("api", []jModel.KeyValue{test.tag})
+			logs := []jModel.Log{{Fields: []jModel.KeyValue{test.tag}}}
+			span := &jModel.Span{Tags: []jModel.KeyValue{test.tag}, Process: process, Logs: logs}
+
+			redactor.Redact(span)
+
+			for name, kv := range map[string]jModel.KeyValue{"tag": span.Tags[0], "process tag": span.Process.Tags[0], "log field": span.Logs[0].Fields[0]} {
+				if kv.Key != test.tag.Key || kv.AsString() != test.wantValue {
+					t.Errorf("%s redacted to %s=%q, want %s=%q", name, kv.Key, kv.AsString(), test.tag.Key, test.wantValue)
+				}
+			}
+
+			// The process and the logs may be shared with other spans.
+			if process.Tags[0].AsString() != test.tag.AsString() || logs[0].Fields[0].AsString() != test.tag.AsString() {
+				t.Error("shared process or logs changed in place")
+			}
+		})
+	}
+}
+
+// TestValue checks a searched value is redacted the way the stored one was, so hashed values are found by their plain value.
+func TestValue(t *testing.T) {
+	redactor, err := New(model.Configuration{
+		RedactionKey:       testKey,
+		RedactionRules:     []model.RedactionRule{{Key: "user.id", Action: ActionHash}},
+		RedactionDetectors: []model.RedactionDetector{{Name: "email", Action: ActionHash}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	span := &jModel.Span{Tags: []jModel.KeyValue{jModel.String("user.id", "42"), jModel.String("to", "jane@example.com")}}
+	redactor.Redact(span)
+
+	if got := redactor.Value("user.id", "42"); got != span.Tags[0].AsString() {
+		t.Errorf("searched user.id redacted to %q, stored as %q", got, span.Tags[0].AsString())
+	}
+	if got := redactor.Value("to", "jane@example.com"); got != span.Tags[1].AsString() {
+		t.Errorf("searched email redacted to %q, stored as %q", got, span.Tags[1].AsString())
+	}
+}
+
+func TestNewRejectsInvalidConfiguration(t *testing.T) {
+	tests := map[string]model.Configuration{
+		"hash without key":          {RedactionRules: []model.RedactionRule{{Key: "password", Action: ActionHash}}},
+		"unknown action":            {RedactionRules: []model.RedactionRule{{Key: "password", Action: "drop"}}},
+		"unknown detector":          {RedactionDetectors: []model.RedactionDetector{{Name: "passport", Action: ActionMask}}},
+		"invalid pattern":           {RedactionDetectors: []model.RedactionDetector{{Name: "broken", Pattern: "(", Action: ActionMask}}},
+		"hash detector without key": {RedactionDetectors: []model.RedactionDetector{{Name: "email", Action: ActionHash}}},
+	}
+
+	for name, config := range tests {
+		if _, err := New(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	redactor, err := New(model.Configuration{})
+	if err != nil || redactor != nil {
+		t.Errorf("expected no redactor without rules nor detectors, got %v, %v", redactor, err)
+	}
+}
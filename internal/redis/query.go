@@ -0,0 +1,31 @@
+package redis
+
+import (
+	"strings"
+	"unicode"
+)
+
+// EscapeTag escapes the value for a TAG query, @field:{value}.
+// Punctuation and whitespace would otherwise split the value or end the tag, so each of them is preceded by a backslash.
+func EscapeTag(value string) string {
+	var b strings.Builder
+	b.Grow(len(value))
+	for _, r := range value {
+		if isQuerySyntax(r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// isQuerySyntax reports whether the rune is part of the query syntax or separates words, outside the letters and digits of a value.
+func isQuerySyntax(r rune) bool {
+	if r == '_' {
+		return false
+	}
+	if r < unicode.MaxASCII {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r) || unicode.IsControl(r)
+	}
+	return unicode.IsSpace(r)
+}
@@ -0,0 +1,25 @@
+package redis
+
+import "testing"
+
+func TestEscapeTag(t *testing.T) {
+	tests := map[string]string{
+		"":                   "",
+		"frontend":           "frontend",
+		"snake_case":         "snake_case",
+		"HTTP GET /customer": `HTTP\ GET\ \/customer`,
+		"a-b.c":              `a\-b\.c`,
+		"{}|\\":              `\{\}\|\\`,
+		"user@example.com":   `user\@example\.com`,
+		"key=value":          `key\=value`,
+		"tab\tnew\nline":     "tab\\\tnew\\\nline",
+		"日本 語":               `日本\ 語`,
+		"\x1f":               "\\\x1f",
+	}
+
+	for value, want := range tests {
+		if got := EscapeTag(value); got != want {
+			t.Errorf("EscapeTag(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -6,13 +6,8 @@ const (
 	field_tokenization = ",.<>{}[]\"':;!@#$%^&*()-+=~"
 )
 
-func Tokenization(value string) string {
-	for _, char := range field_tokenization {
-		value = strings.Replace(value, string(char), ("\\" + string(char)), -1)
-	}
-	return value
-}
-
+// UnTokenization reverts the escaping earlier versions applied to the service and operation names they stored.
+// Names are now stored raw and only escaped when building queries, see EscapeTag.
 func UnTokenization(value string) string {
 	for _, char := range field_tokenization {
 		value = strings.Replace(value, ("\\" + string(char)), string(char), -1)
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/rueian/rueidis"
+)
+
+const (
+	// DurabilityFireAndForget returns before Redis replies, write errors are only logged.
+	DurabilityFireAndForget = "fire-and-forget"
+	// DurabilityPrimaryAck returns once the primary has accepted the write.
+	DurabilityPrimaryAck = "primary-ack"
+	// DurabilityReplicaAck returns once the write has reached the configured number of replicas, using WAIT.
+	DurabilityReplicaAck = "replica-ack"
+
+	// fireAndForgetMaxInflight bounds the batches written in the background, callers wait once it is reached.
+	fireAndForgetMaxInflight = 64
+)
+
+// durability controls how long span writes wait before being reported as done.
+type durability struct {
+	mode     string
+	replicas int64
+	timeout  time.Duration
+	inflight chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newDurability(context context.Context, client rueidis.Client, config model.Configuration) (*durability, error) {
+	switch config.WriteDurability {
+	case DurabilityFireAndForget, DurabilityPrimaryAck, DurabilityReplicaAck:
+	default:
+		return nil, fmt.Errorf("invalid write durability: %s", config.WriteDurability)
+	}
+
+	// The indexer acknowledges the stream entries once their spans are written, which fire-and-forget writes never report.
+	if config.WriteDurability == DurabilityFireAndForget && config.StreamEnabled {
+		return nil, fmt.Errorf("%s durability cannot be used with stream_enabled", DurabilityFireAndForget)
+	}
+
+	if config.WriteDurability == DurabilityReplicaAck {
+		if config.WriteReplicas < 1 {
+			return nil, fmt.Errorf("write_replicas must be at least 1 with %s durability", DurabilityReplicaAck)
+		}
+
+		// In cluster mode the writes of a pipeline go to the nodes owning their keys, while WAIT only covers the node it reaches.
+		info, err := client.Do(context, client.B().Info().Section("cluster").Build()).ToString()
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(info, "cluster_enabled:1") {
+			return nil, fmt.Errorf("%s durability is not supported in cluster mode", DurabilityReplicaAck)
+		}
+	}
+
+	return &durability{
+		mode:     config.WriteDurability,
+		replicas: config.WriteReplicas,
+		timeout:  config.WriteReplicaTimeout,
+		inflight: make(chan struct{}, fireAndForgetMaxInflight),
+	}, nil
+}
+
+// doMulti sends the commands as a pipeline. With replica-ack durability, the pipeline ends with a WAIT,
+// and the returned error is set when the writes did not reach enough replicas in time.
+// WAIT is a blocking command, so the whole pipeline is sent on a dedicated connection WAIT applies to.
+func (d *durability) doMulti(context context.Context, client rueidis.Client, cmds rueidis.Commands) ([]rueidis.RedisResult, error) {
+	if d.mode != DurabilityReplicaAck || len(cmds) == 0 {
+		return client.DoMulti(context, cmds...), nil
+	}
+
+	cmds = append(cmds, client.B().Wait().Numreplicas(d.replicas).Timeout(d.timeout.Milliseconds()).Build())
+	resps := client.DoMulti(context, cmds...)
+
+	replicas, err := resps[len(resps)-1].AsInt64()
+	if err == nil && replicas < d.replicas {
+		err = fmt.Errorf("write acknowledged by %d of %d replicas", replicas, d.replicas)
+	}
+	return resps[:len(resps)-1], err
+}
+
+// background runs write without waiting for it, waiting only when too many writes are running already.
+func (d *durability) background(write func()) {
+	d.inflight <- struct{}{}
+	d.wg.Add(1)
+	go func() {
+		defer func() {
+			<-d.inflight
+			d.wg.Done()
+		}()
+		write()
+	}()
+}
+
+// observe records the latency of a write under the durability mode.
+func (d *durability) observe(start time.Time, err error) {
+	status := "Ok"
+	if err != nil {
+		status = "Error"
+	}
+	metrics.WriteDurabilityLatency.WithLabelValues(d.mode, status).Observe(time.Since(start).Seconds())
+}
+
+// Close waits for the writes running in the background.
+func (d *durability) Close() {
+	d.wg.Wait()
+}
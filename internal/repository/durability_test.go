@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+)
+
+func TestNewDurabilityRejectsInvalidConfiguration(t *testing.T) {
+	tests := map[string]model.Configuration{
+		"unknown mode":                   {WriteDurability: "eventually"},
+		"replica-ack without replicas":   {WriteDurability: DurabilityReplicaAck},
+		"fire-and-forget with streaming": {WriteDurability: DurabilityFireAndForget, StreamEnabled: true},
+	}
+
+	for name, config := range tests {
+		if _, err := newDurability(context.Background(), nil, config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	for _, mode := range []string{DurabilityFireAndForget, DurabilityPrimaryAck} {
+		if _, err := newDurability(context.Background(), nil, model.Configuration{WriteDurability: mode}); err != nil {
+			t.Errorf("%s: %v", mode, err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+	"github.com/rueian/rueidis"
+)
+
+const traceSpansKeyPrefix = "trace-spans"
+
+// guardrails keeps oversized spans and runaway traces from swamping the spans index.
+// A limit set to 0 is disabled.
+type guardrails struct {
+	maxTagValueLength int
+	maxTagsPerSpan    int
+	maxLogsPerSpan    int
+	maxSpansPerTrace  int64
+}
+
+func newGuardrails(config model.Configuration) guardrails {
+	return guardrails{
+		maxTagValueLength: config.MaxTagValueLength,
+		maxTagsPerSpan:    config.MaxTagsPerSpan,
+		maxLogsPerSpan:    config.MaxLogsPerSpan,
+		maxSpansPerTrace:  config.MaxSpansPerTrace,
+	}
+}
+
+// limitSpan truncates the tag values and drops the tags and logs over the limits, recording what was done in the span warnings.
+// Applying it twice to the same span changes nothing the second time. The process may be shared with other spans,
+// so truncated process tags and log fields are copied rather than changed in place.
+func (g guardrails) limitSpan(jSpan *jModel.Span) {
+	service := jSpan.Process.ServiceName
+
+	if g.maxTagsPerSpan > 0 && len(jSpan.Tags) > g.maxTagsPerSpan {
+		dropped := len(jSpan.Tags) - g.maxTagsPerSpan
+		jSpan.Tags = jSpan.Tags[:g.maxTagsPerSpan]
+		jSpan.Warnings = append(jSpan.Warnings, fmt.Sprintf("%d tags dropped, exceeding the limit of %d tags per span", dropped, g.maxTagsPerSpan))
+		metrics.GuardrailDroppedTags.WithLabelValues(service).Add(float64(dropped))
+	}
+
+	if g.maxLogsPerSpan > 0 && len(jSpan.Logs) > g.maxLogsPerSpan {
+		dropped := len(jSpan.Logs) - g.maxLogsPerSpan
+		jSpan.Logs = jSpan.Logs[:g.maxLogsPerSpan]
+		jSpan.Warnings = append(jSpan.Warnings, fmt.Sprintf("%d logs dropped, exceeding the limit of %d logs per span", dropped, g.maxLogsPerSpan))
+		metrics.GuardrailDroppedLogs.WithLabelValues(service).Add(float64(dropped))
+	}
+
+	if g.maxTagValueLength <= 0 {
+		return
+	}
+
+	var truncated, n int
+	jSpan.Tags, truncated = g.truncateValues(jSpan.Tags)
+
+	if tags, n := g.truncateValues(jSpan.Process.Tags); n > 0 {
+		process := *jSpan.Process
+		process.Tags = tags
+		jSpan.Process = &process
+		truncated += n
+	}
+
+	logs := make([]jModel.Log, len(jSpan.Logs))
+	logsTruncated := 0
+	for i, log := range jSpan.Logs {
+		logs[i] = jModel.Log{Timestamp: log.Timestamp}
+		logs[i].Fields, n = g.truncateValues(log.Fields)
+		logsTruncated += n
+	}
+	if logsTruncated > 0 {
+		jSpan.Logs = logs
+		truncated += logsTruncated
+	}
+
+	if truncated > 0 {
+		jSpan.Warnings = append(jSpan.Warnings, fmt.Sprintf("%d tag values truncated to %d bytes", truncated, g.maxTagValueLength))
+		metrics.GuardrailTruncatedValues.WithLabelValues(service).Add(float64(truncated))
+	}
+}
+
+// truncateValues returns kvs with the values over the limit truncated, and how many were. kvs is copied
+// when a value is truncated, never changed in place.
+func (g guardrails) truncateValues(kvs []jModel.KeyValue) ([]jModel.KeyValue, int) {
+	var result []jModel.KeyValue
+	truncated := 0
+	for i := range kvs {
+		if len(kvs[i].VStr) <= g.maxTagValueLength && len(kvs[i].VBinary) <= g.maxTagValueLength {
+			continue
+		}
+
+		if result == nil {
+			result = append([]jModel.KeyValue{}, kvs...)
+		}
+		if len(kvs[i].VStr) > g.maxTagValueLength {
+			result[i].VStr = truncateString(kvs[i].VStr, g.maxTagValueLength)
+			truncated++
+		}
+		if len(kvs[i].VBinary) > g.maxTagValueLength {
+			result[i].VBinary = kvs[i].VBinary[:g.maxTagValueLength]
+			truncated++
+		}
+	}
+
+	if result == nil {
+		return kvs, 0
+	}
+	return result, truncated
+}
+
+// truncateString cuts value to at most length bytes without splitting a UTF-8 character.
+func truncateString(value string, length int) string {
+	for length > 0 && !utf8.RuneStart(value[length]) {
+		length--
+	}
+	return value[:length]
+}
+
+// admitSpans counts the spans of each trace in Redis and returns whether each span is within the trace limit.
+// Counting is shared by all plugin instances. A span received more than once from clients is counted each time,
+// so the limit is reached a bit early when spans are retried; spans replayed from the spill file or redelivered
+// by the stream are written through RewriteBatch and not counted again.
+// Spans are admitted when they cannot be counted, a guardrail must not lose data on its own.
+func (g guardrails) admitSpans(context context.Context, client rueidis.Client, jSpans []*jModel.Span, ttl int64) []bool {
+	if g.maxSpansPerTrace <= 0 {
+		return admitAll(len(jSpans))
+	}
+
+	admitted := make([]bool, len(jSpans))
+
+	cmds := make(rueidis.Commands, 0, len(jSpans)*2)
+	for _, jSpan := range jSpans {
+		key := fmt.Sprintf("%s:%s", traceSpansKeyPrefix, jSpan.TraceID.String())
+		cmds = append(cmds,
+			client.B().Incr().Key(key).Build(),
+			client.B().Expire().Key(key).Seconds(ttl).Build())
+	}
+
+	for i, resp := range client.DoMulti(context, cmds...) {
+		if i%2 != 0 {
+			continue
+		}
+
+		count, err := resp.AsInt64()
+		admitted[i/2] = err != nil || count <= g.maxSpansPerTrace
+		if !admitted[i/2] {
+			metrics.GuardrailDroppedSpans.WithLabelValues(jSpans[i/2].Process.ServiceName).Inc()
+		}
+	}
+	return admitted
+}
+
+func admitAll(n int) []bool {
+	admitted := make([]bool, n)
+	for i := range admitted {
+		admitted[i] = true
+	}
+	return admitted
+}
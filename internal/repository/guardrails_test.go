@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+func guardedSpan(process *jModel.Process) *jModel.Span {
+	return &jModel.Span{
+		TraceID: jModel.NewTraceID(0, 1),
+		SpanID:  jModel.NewSpanID(1),
+		Process: process,
+		Tags:    []jModel.KeyValue{jModel.String("a", "0123456789"), jModel.String("b", "short"), jModel.String("c", "x")},
+		Logs: []jModel.Log{
+			{Fields: []jModel.KeyValue{jModel.String("event", "0123456789")}},
+			{Fields: []jModel.KeyValue{jModel.String("event", "ok")}},
+		},
+	}
+}
+
+func TestGuardrailsLimitSpan(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   model.Configuration
+		tags     int
+		logs     int
+		value    string
+		warnings int
+	}{
+		{name: "no limit", config: model.Configuration{}, tags: 3, logs: 2, value: "0123456789"},
+		{name: "tags per span", config: model.Configuration{MaxTagsPerSpan: 2}, tags: 2, logs: 2, value: "0123456789", warnings: 1},
+		{name: "logs per span", config: model.Configuration{MaxLogsPerSpan: 1}, tags: 3, logs: 1, value: "0123456789", warnings: 1},
+		{name: "tag value length", config: model.Configuration{MaxTagValueLength: 6}, tags: 3, logs: 2, value: "012345", warnings: 1},
+		{name: "every limit", config: model.Configuration{MaxTagsPerSpan: 1, MaxLogsPerSpan: 1, MaxTagValueLength: 6}, tags: 1, logs: 1, value: "012345", warnings: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := newGuardrails(test.config)
+			span := guardedSpan(jModel.NewProcess("api", nil))
+
+			g.limitSpan(span)
+			if len(span.Tags) != test.tags || len(span.Logs) != test.logs {
+				t.Errorf("kept %d tags and %d logs, want %d and %d", len(span.Tags), len(span.Logs), test.tags, test.logs)
+			}
+			if span.Tags[0].VStr != test.value || span.Logs[0].Fields[0].VStr != test.value {
+				t.Errorf("values %q and %q, want %q", span.Tags[0].VStr, span.Logs[0].Fields[0].VStr, test.value)
+			}
+			if len(span.Warnings) != test.warnings {
+				t.Errorf("warnings %v, want %d", span.Warnings, test.warnings)
+			}
+
+			g.limitSpan(span)
+			if len(span.Warnings) != test.warnings {
+				t.Errorf("limiting the span again added warnings %v", span.Warnings)
+			}
+		})
+	}
+}
+
+// TestGuardrailsCopySharedProcess checks truncating the process tags of a span leaves the process it shares with other spans untouched.
+func TestGuardrailsCopySharedProcess(t *testing.T) {
+	process := jModel.NewProcess("api", []jModel.KeyValue{jModel.String("host", strings.Repeat("h", 10))})
+	first, second := guardedSpan(process), guardedSpan(process)
+	logs := second.Logs
+
+	g := newGuardrails(model.Configuration{MaxTagValueLength: 4})
+	g.limitSpan(first)
+
+	if process.Tags[0].VStr != strings.Repeat("h", 10) {
+		t.Fatalf("shared process tag changed in place to %q", process.Tags[0].VStr)
+	}
+	if first.Process == process || first.Process.Tags[0].VStr != "hhhh" {
+		t.Errorf("process tag of the limited span is %q", first.Process.Tags[0].VStr)
+	}
+
+	g.limitSpan(second)
+	if logs[0].Fields[0].VStr != "0123456789" {
+		t.Errorf("log field changed in place to %q", logs[0].Fields[0].VStr)
+	}
+}
+
+func TestGuardrailsAdmitWithoutTraceLimit(t *testing.T) {
+	spans := []*jModel.Span{guardedSpan(jModel.NewProcess("api", nil)), guardedSpan(jModel.NewProcess("api", nil))}
+	for i, ok := range newGuardrails(model.Configuration{}).admitSpans(context.TODO(), nil, spans, 60) {
+		if !ok {
+			t.Errorf("span %d not admitted without a trace limit", i)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rueian/rueidis"
+	"github.com/rueian/rueidis/om"
+)
+
+const (
+	indexLockKeyPrefix = "index-lock"
+	// indexLockTTL bounds how long an index stays locked when the instance recreating it stops halfway.
+	indexLockTTL = time.Minute
+)
+
+// identifierSeparator separates the values of exact TAG fields in place of the default comma, which names may hold.
+// The byte never appears in UTF-8 text, so every name and tag pair is indexed and matched as a whole.
+const identifierSeparator = "\xff"
+
+// indexField declares a field of an index.
+type indexField struct {
+	path  string
+	name  string
+	kind  string // TAG, TEXT or NUMERIC
+	exact bool   // TAG field matched exactly: case sensitive and only split on identifierSeparator
+}
+
+// indexSchema declares the fields of an index, the index being created again when the existing one differs.
+type indexSchema []indexField
+
+// indexAttribute is a field of an existing index, as listed by FT.INFO.
+type indexAttribute struct {
+	kind      string
+	separator string
+}
+
+// ensureIndex creates the index of the documents stored under prefix. When the index already exists with another schema,
+// the index is dropped and created again. Dropping an index keeps its documents, RediSearch indexes them again in the background.
+// Instances starting together would drop the index each other just created, so only the one holding a lock recreates it.
+func ensureIndex(context context.Context, client rueidis.Client, index string, prefix string, schema indexSchema) error {
+	err := client.Do(context, schema.create(client, index, prefix)).Error()
+	if err == nil || !strings.Contains(err.Error(), "Index already exists") {
+		return err
+	}
+
+	fields, err := indexFields(context, client, index)
+	if err != nil || !schema.stale(fields) {
+		return err
+	}
+
+	lock := fmt.Sprintf("%v:%v", indexLockKeyPrefix, index)
+	err = client.Do(context, client.B().Set().Key(lock).Value("1").Nx().PxMilliseconds(indexLockTTL.Milliseconds()).Build()).Error()
+	if rueidis.IsRedisNil(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer client.Do(context, client.B().Del().Key(lock).Build())
+
+	if err := client.Do(context, client.B().FtDropindex().Index(index).Build()).Error(); err != nil && !strings.Contains(err.Error(), "Unknown Index name") {
+		return err
+	}
+
+	err = client.Do(context, schema.create(client, index, prefix)).Error()
+	if err != nil && strings.Contains(err.Error(), "Index already exists") {
+		return nil
+	}
+	return err
+}
+
+// create returns the FT.CREATE command of the index. The command builder has no option for exact TAG fields,
+// so the command is built from its arguments.
+func (s indexSchema) create(client rueidis.Client, index string, prefix string) om.Completed {
+	args := []string{index, "ON", "JSON", "PREFIX", "1", prefix + ":", "SCHEMA"}
+	for _, field := range s {
+		args = append(args, field.path, "AS", field.name, field.kind)
+		if field.exact {
+			args = append(args, "SEPARATOR", identifierSeparator, "CASESENSITIVE")
+		}
+	}
+	return client.B().Arbitrary("FT.CREATE").Args(args...).Build()
+}
+
+// stale reports whether the fields of the existing index differ from the declared ones.
+// Separators are only compared when FT.INFO lists them.
+func (s indexSchema) stale(fields map[string]indexAttribute) bool {
+	if len(fields) != len(s) {
+		return true
+	}
+
+	for _, field := range s {
+		attribute, ok := fields[field.name]
+		if !ok || attribute.kind != field.kind {
+			return true
+		}
+		if attribute.separator != "" && field.exact != (attribute.separator == identifierSeparator) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexFields returns the fields of the index, by field name, as listed by FT.INFO.
+func indexFields(context context.Context, client rueidis.Client, index string) (map[string]indexAttribute, error) {
+	info, err := client.Do(context, client.B().FtInfo().Index(index).Build()).ToAny()
+	if err != nil {
+		return nil, err
+	}
+	return infoFields(info), nil
+}
+
+// infoFields reads the fields of an FT.INFO reply.
+func infoFields(info interface{}) map[string]indexAttribute {
+	fields := map[string]indexAttribute{}
+	for _, attribute := range infoValue(info, "attributes") {
+		switch attribute := attribute.(type) {
+		case map[string]interface{}:
+			name, _ := attribute["attribute"].(string)
+			kind, _ := attribute["type"].(string)
+			separator, _ := attribute["SEPARATOR"].(string)
+			fields[name] = indexAttribute{kind: kind, separator: separator}
+		case []interface{}:
+			// RESP2 replies list each field as "identifier", <path>, "attribute", <name>, "type", <type>, followed by its options.
+			var name string
+			var field indexAttribute
+			for i := 0; i+1 < len(attribute); i++ {
+				switch attribute[i] {
+				case "attribute":
+					name, _ = attribute[i+1].(string)
+				case "type":
+					field.kind, _ = attribute[i+1].(string)
+				case "SEPARATOR":
+					field.separator, _ = attribute[i+1].(string)
+				}
+			}
+			fields[name] = field
+		}
+	}
+	return fields
+}
+
+// infoValue returns the list under key of an FT.INFO reply, which is a map with RESP3 and a flat list of pairs with RESP2.
+func infoValue(info interface{}, key string) []interface{} {
+	switch info := info.(type) {
+	case map[string]interface{}:
+		values, _ := info[key].([]interface{})
+		return values
+	case []interface{}:
+		for i := 0; i+1 < len(info); i += 2 {
+			if info[i] == key {
+				values, _ := info[i+1].([]interface{})
+				return values
+			}
+		}
+	}
+	return nil
+}
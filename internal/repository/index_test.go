@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndexSchemaStale(t *testing.T) {
+	schema := indexSchema{
+		{path: "$.service", name: "service", kind: "TAG", exact: true},
+		{path: "$.kind", name: "kind", kind: "TAG"},
+		{path: "$.startTime", name: "startTime", kind: "NUMERIC"},
+	}
+
+	tests := []struct {
+		name   string
+		fields map[string]indexAttribute
+		want   bool
+	}{
+		{
+			name: "same fields",
+			fields: map[string]indexAttribute{
+				"service":   {kind: "TAG", separator: identifierSeparator},
+				"kind":      {kind: "TAG", separator: ","},
+				"startTime": {kind: "NUMERIC"},
+			},
+		},
+		{
+			name:   "separators not listed",
+			fields: map[string]indexAttribute{"service": {kind: "TAG"}, "kind": {kind: "TAG"}, "startTime": {kind: "NUMERIC"}},
+		},
+		{
+			name:   "field of another type",
+			fields: map[string]indexAttribute{"service": {kind: "TEXT"}, "kind": {kind: "TAG"}, "startTime": {kind: "NUMERIC"}},
+			want:   true,
+		},
+		{
+			name:   "exact field split on commas",
+			fields: map[string]indexAttribute{"service": {kind: "TAG", separator: ","}, "kind": {kind: "TAG"}, "startTime": {kind: "NUMERIC"}},
+			want:   true,
+		},
+		{
+			name:   "missing field",
+			fields: map[string]indexAttribute{"service": {kind: "TAG"}, "kind": {kind: "TAG"}},
+			want:   true,
+		},
+		{
+			name:   "obsolete field",
+			fields: map[string]indexAttribute{"service": {kind: "TAG"}, "kind": {kind: "TAG"}, "startTime": {kind: "NUMERIC"}, "tagValue": {kind: "TAG"}},
+			want:   true,
+		},
+	}
+
+	for _, test := range tests {
+		if got := schema.stale(test.fields); got != test.want {
+			t.Errorf("%s: stale %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestInfoFields(t *testing.T) {
+	want := map[string]indexAttribute{
+		"traceID":   {kind: "TAG", separator: identifierSeparator},
+		"startTime": {kind: "NUMERIC"},
+	}
+
+	replies := map[string]interface{}{
+		"RESP2": []interface{}{
+			"index_name", "jsonidx:spans",
+			"attributes", []interface{}{
+				[]interface{}{"identifier", "$.traceID", "attribute", "traceID", "type", "TAG", "SEPARATOR", identifierSeparator, "CASESENSITIVE"},
+				[]interface{}{"identifier", "$.startTime", "attribute", "startTime", "type", "NUMERIC"},
+			},
+		},
+		"RESP3": map[string]interface{}{
+			"index_name": "jsonidx:spans",
+			"attributes": []interface{}{
+				map[string]interface{}{"identifier": "$.traceID", "attribute": "traceID", "type": "TAG", "SEPARATOR": identifierSeparator},
+				map[string]interface{}{"identifier": "$.startTime", "attribute": "startTime", "type": "NUMERIC"},
+			},
+		},
+	}
+
+	for protocol, reply := range replies {
+		if fields := infoFields(reply); !reflect.DeepEqual(fields, want) {
+			t.Errorf("%s: read fields %v, want %v", protocol, fields, want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/rueian/rueidis"
+)
+
+const (
+	migrationKeyPrefix = "migration"
+	migrationScanCount = 1000
+)
+
+// migration rewrites the documents written by earlier versions. It returns the commands rewriting the document,
+// none when the document is already up to date.
+type migration struct {
+	name     string
+	prefixes []string
+	commands func(client rueidis.Client, prefix string, key string, data string) rueidis.Commands
+}
+
+var migrations = []migration{
+	// Earlier versions stored names escaped, while searches now escape the raw names they are given.
+	{name: "raw-names", prefixes: []string{spanIndexName, operationIndexName}, commands: rawNamesCommands},
+	// Earlier versions indexed tag keys and values apart, while searches now match key=value pairs.
+	{name: "tag-pairs", prefixes: []string{spanIndexName}, commands: tagPairsCommands},
+	// Earlier versions stored the merged tags of each span, which tag pairs replaced. It runs after tag-pairs, which reads them.
+	{name: "merged-tags", prefixes: []string{spanIndexName}, commands: mergedTagsCommands},
+}
+
+// Migrate rewrites the span and operation documents written by earlier versions, so searches find them.
+// Each migration runs once, recording its completion in Redis.
+func Migrate(context context.Context, logger hclog.Logger, client rueidis.Client) error {
+	for _, migration := range migrations {
+		if err := migrate(context, logger, client, migration); err != nil {
+			return fmt.Errorf("migration %s: %w", migration.name, err)
+		}
+	}
+	return nil
+}
+
+func migrate(context context.Context, logger hclog.Logger, client rueidis.Client, migration migration) error {
+	doneKey := fmt.Sprintf("%v:%v", migrationKeyPrefix, migration.name)
+	done, err := client.Do(context, client.B().Exists().Key(doneKey).Build()).AsInt64()
+	if err != nil {
+		return err
+	}
+	if done > 0 {
+		return nil
+	}
+
+	migrated, failed := 0, 0
+	for _, node := range client.Nodes() {
+		for _, prefix := range migration.prefixes {
+			cursor := int64(0)
+			for {
+				reply, err := node.Do(context, client.B().Scan().Cursor(cursor).Match(prefix+":*").Count(migrationScanCount).Build()).ToArray()
+				if err != nil {
+					return err
+				}
+				if len(reply) != 2 {
+					return fmt.Errorf("unexpected SCAN reply of %d elements", len(reply))
+				}
+
+				next, err := reply[0].ToString()
+				if err != nil {
+					return err
+				}
+				if cursor, err = strconv.ParseInt(next, 10, 64); err != nil {
+					return err
+				}
+
+				keys, err := reply[1].AsStrSlice()
+				if err != nil {
+					return err
+				}
+
+				for _, key := range keys {
+					data, err := client.Do(context, client.B().JsonGet().Key(key).Build()).ToString()
+					if rueidis.IsRedisNil(err) {
+						continue
+					}
+					if err != nil {
+						return err
+					}
+
+					cmds := migration.commands(client, prefix, key, data)
+					if len(cmds) == 0 {
+						continue
+					}
+
+					// The version is bumped so merges that read the document before it was rewritten read it again.
+					cmds = append(cmds, client.B().JsonNumincrby().Key(key).Path("$.ver").Value(1).Build())
+
+					// The document may expire in between, in which case its paths can no longer be set.
+					ok := true
+					for _, resp := range client.DoMulti(context, cmds...) {
+						if err := resp.Error(); err != nil && !rueidis.IsRedisNil(err) {
+							ok = false
+						}
+					}
+					if ok {
+						migrated++
+					} else {
+						failed++
+					}
+				}
+
+				if cursor == 0 {
+					break
+				}
+			}
+		}
+	}
+
+	logger.Warn("documents of earlier versions migrated", "migration", migration.name, "migrated", migrated, "failed", failed)
+	return client.Do(context, client.B().Set().Key(doneKey).Value("1").Build()).Error()
+}
+
+// rawNamesCommands sets the raw names of a document whose names are escaped.
+// Reads unescape the documents not rewritten yet.
+func rawNamesCommands(client rueidis.Client, prefix string, key string, data string) rueidis.Commands {
+	names := map[string]string{}
+	switch prefix {
+	case spanIndexName:
+		span := &model.Span{}
+		if err := json.Unmarshal([]byte(data), span); err != nil || span.RawNames {
+			return nil
+		}
+		span.UnescapeNames()
+		names["$.operationName"] = span.OperationName
+		names["$.catalogName"] = span.CatalogName
+		names["$.process.serviceName"] = span.Process.ServiceName
+	case operationIndexName:
+		operation := &model.Operation{}
+		if err := json.Unmarshal([]byte(data), operation); err != nil || operation.RawNames {
+			return nil
+		}
+		operation.UnescapeNames()
+		names["$.service"] = operation.ServiceName
+		names["$.operation"] = operation.OperationName
+	}
+
+	cmds := make(rueidis.Commands, 0, len(names)+1)
+	for path, name := range names {
+		cmds = append(cmds, client.B().JsonSet().Key(key).Path(path).Value(rueidis.JSON(name)).Xx().Build())
+	}
+	cmds = append(cmds, client.B().JsonSet().Key(key).Path("$.rawNames").Value("true").Build())
+	return cmds
+}
+
+// tagPairsCommands sets the tag pairs of a span document that has none, derived from its merged tags.
+// Earlier documents only hold the first value of each key, so spans are not found by the values of repeated keys.
+func tagPairsCommands(client rueidis.Client, prefix string, key string, data string) rueidis.Commands {
+	span := &model.Span{}
+	if err := json.Unmarshal([]byte(data), span); err != nil || len(span.TagPairs) > 0 || len(span.MultipleTags) == 0 {
+		return nil
+	}
+
+	pairs := make([]string, 0, len(span.MultipleTags))
+	for _, kv := range span.MultipleTags {
+		pairs = append(pairs, model.TagPair(kv.Key, fmt.Sprint(kv.Value)))
+	}
+	return rueidis.Commands{client.B().JsonSet().Key(key).Path("$.tagPairs").Value(rueidis.JSON(pairs)).Build()}
+}
+
+// mergedTagsCommands deletes the merged tags of a span document.
+func mergedTagsCommands(client rueidis.Client, prefix string, key string, data string) rueidis.Commands {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return nil
+	}
+	if _, ok := fields["mTags"]; !ok {
+		return nil
+	}
+	return rueidis.Commands{client.B().JsonDel().Key(key).Path("$.mTags").Build()}
+}
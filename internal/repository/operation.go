@@ -17,132 +17,288 @@ import (
 	"github.com/rueian/rueidis/om"
 )
 
-const operationIndexName = "operation"
+const (
+	operationIndexName        = "operation"
+	operationQueueSize        = 10000
+	operationBatchSize        = 100
+	operationMaxAttempts      = 5
+	operationRetryBaseBackoff = time.Second
+)
+
+type pendingOperation struct {
+	operation *model.Operation
+	attempt   int
+}
 
+// OperationRepository maintains the service and operation catalog.
+// Catalog entries are registered in the background, so writing spans never waits for them or fails because of them.
 type OperationRepository struct {
 	logger     hclog.Logger
 	repository om.Repository[model.Operation]
-	mu         sync.Mutex
 	client     rueidis.Client
 	config     model.Configuration
+	normalizer *operationNormalizer
+	resilience *Resilience
+	seen       sync.Map
+	pending    chan *pendingOperation
+	done       chan struct{}
+	closed     chan struct{}
 }
 
-func NewOperationRepository(logger hclog.Logger, redisClient rueidis.Client, config model.Configuration) (*OperationRepository, error) {
+func NewOperationRepository(logger hclog.Logger, redisClient rueidis.Client, config model.Configuration, resilience *Resilience) (*OperationRepository, error) {
 	repository := om.NewJSONRepository(operationIndexName, model.Operation{}, redisClient)
-	if _, ok := repository.(*om.JSONRepository[model.Operation]); ok {
-		createOperationIndex(repository)
+	if jsonRepository, ok := repository.(*om.JSONRepository[model.Operation]); ok {
+		if err := ensureIndex(context.TODO(), redisClient, jsonRepository.IndexName(), operationIndexName, operationSchema); err != nil {
+			return nil, fmt.Errorf("unable to create operation index: %w", err)
+		}
 	}
-	return &OperationRepository{
+	normalizer, err := newOperationNormalizer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	operationRepository := &OperationRepository{
 		logger:     logger,
 		repository: repository,
-		mu:         sync.Mutex{},
 		client:     redisClient,
 		config:     config,
-	}, nil
+		normalizer: normalizer,
+		resilience: resilience,
+		pending:    make(chan *pendingOperation, operationQueueSize),
+		done:       make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	go operationRepository.run()
+	return operationRepository, nil
 }
 
-func createOperationIndex(repository om.Repository[model.Operation]) {
-	repository.CreateIndex(context.TODO(), func(schema om.FtCreateSchema) om.Completed {
-		text := schema.FieldName("$.service").As("service").Text()
-		text = text.FieldName("$.operation").As("operation").Text()
-		text = text.FieldName("$.span_kind").As("span_kind").Text()
-		text = text.FieldName("$.hash").As("hash").Text()
-		return text.Build()
-	})
+// operationSchema declares the operation index. Services and operations are matched exactly, earlier operation indexes
+// declared them as TEXT fields, so the operations of a service named api were listed along the ones of api-gateway.
+var operationSchema = indexSchema{
+	{path: "$.service", name: "service", kind: "TAG", exact: true},
+	{path: "$.operation", name: "operation", kind: "TAG", exact: true},
+	{path: "$.span_kind", name: "span_kind", kind: "TEXT"},
+	{path: "$.hash", name: "hash", kind: "TEXT"},
 }
 
-func (s *OperationRepository) Write(context context.Context, jaegerSpan *jModel.Span) error {
-	s.mu.Lock()
-	writeStart := time.Now()
-	defer s.mu.Unlock()
+// Normalize returns the name the operation of the span is registered under in the catalog.
+func (s *OperationRepository) Normalize(jaegerSpan *jModel.Span) string {
+	return s.normalizer.Normalize(jaegerSpan.Process.ServiceName, jaegerSpan.OperationName)
+}
 
-	hash := hashCode(jaegerSpan)
+// Register schedules the operation of the span to be added to the catalog, under the name returned by Normalize.
+// It is called once the span is written, so operations are not listed for spans that were never stored.
+// Operations registered recently are skipped, and Register never blocks: when the backlog is full the operation is
+// dropped and registered again with a later span.
+func (s *OperationRepository) Register(jaegerSpan *jModel.Span, operationName string) {
+	hash := hashCode(jaegerSpan.Process.ServiceName, operationName)
+	now := time.Now()
 
-	n, _, err := s.repository.Search(context, func(search om.FtSearchIndex) om.Completed {
-		return search.Query(hash).Build()
-	})
+	// The catalog entry is refreshed half way through its TTL, so it never expires while the operation is in use.
+	if refreshAt, ok := s.seen.Load(hash); ok && now.Before(refreshAt.(time.Time)) {
+		return
+	}
+	s.seen.Store(hash, now.Add(s.config.RedisTTL/2))
 
-	if err != nil {
-		return err
+	select {
+	case <-s.closed:
+		s.seen.Delete(hash)
+	case s.pending <- &pendingOperation{operation: s.newOperation(jaegerSpan, operationName)}:
+	default:
+		s.seen.Delete(hash)
+		metrics.OperationCatalogDropped.Inc()
 	}
+}
 
-	if n > 0 {
-		return nil
+// Close writes the operations still waiting to be registered and stops the background registration.
+func (s *OperationRepository) Close() error {
+	close(s.closed)
+	<-s.done
+	return nil
+}
+
+func (s *OperationRepository) run() {
+	defer close(s.done)
+
+	for {
+		select {
+		case pending := <-s.pending:
+			s.upsert(s.drain(pending))
+		case <-s.closed:
+			for {
+				select {
+				case pending := <-s.pending:
+					s.upsert(s.drain(pending))
+				default:
+					return
+				}
+			}
+		}
 	}
+}
 
-	spanKind := ""
-	for _, tag := range jaegerSpan.Tags {
-		if tag.Key == "span.kind" {
-			spanKind = tag.AsString()
+func (s *OperationRepository) drain(first *pendingOperation) []*pendingOperation {
+	batch := []*pendingOperation{first}
+	for len(batch) < operationBatchSize {
+		select {
+		case pending := <-s.pending:
+			batch = append(batch, pending)
+		default:
+			return batch
 		}
 	}
+	return batch
+}
+
+// upsert registers the operations unless they are already there, and refreshes the TTL of their catalog entries.
+func (s *OperationRepository) upsert(batch []*pendingOperation) {
+	writeStart := time.Now()
 
-	newSvc := s.repository.NewEntity()
-	newSvc.ServiceName = redis.Tokenization(jaegerSpan.Process.ServiceName)
-	newSvc.OperationName = redis.Tokenization(jaegerSpan.OperationName)
-	newSvc.SpanKind = spanKind
-	newSvc.Hash = hash
+	cmds := make(rueidis.Commands, 0, len(batch)*2)
+	for _, pending := range batch {
+		key := operationKey(pending.operation.Key)
+		cmds = append(cmds,
+			s.client.B().JsonSet().Key(key).Path("$").Value(rueidis.JSON(pending.operation)).Nx().Build(),
+			expireCommand(s.client, key, s.config.RedisTTL))
+	}
 
-	err = s.repository.Save(context, newSvc)
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.RedisWriteTimeout)
+	defer cancel()
+
+	failed := map[int]error{}
+	for i, resp := range s.client.DoMulti(ctx, cmds...) {
+		// JSON.SET NX replies nil when the operation is already registered.
+		if err := resp.Error(); err != nil && !rueidis.IsRedisNil(err) {
+			failed[i/2] = err
+		}
+	}
+
+	for i, pending := range batch {
+		err, ok := failed[i]
+		if !ok {
+			metrics.WritesLantency.WithLabelValues(operationIndexName, "Ok").Observe(time.Since(writeStart).Seconds())
+			metrics.WritesTotal.WithLabelValues(operationIndexName).Inc()
+			continue
+		}
 
-	if err != nil {
 		metrics.WritesLantency.WithLabelValues(operationIndexName, "Error").Observe(time.Since(writeStart).Seconds())
-		return err
+		s.retry(pending, err)
 	}
+}
 
-	setTTL(context, s.client, fmt.Sprintf("%v:%v", operationIndexName, newSvc.Key), s.config.RedisTTL)
+func (s *OperationRepository) retry(pending *pendingOperation, err error) {
+	pending.attempt++
+	if pending.attempt >= operationMaxAttempts {
+		s.logger.Warn("giving up registering operation", "service", pending.operation.ServiceName, "operation", pending.operation.OperationName, "err", err)
+		s.seen.Delete(pending.operation.Hash)
+		metrics.OperationCatalogDropped.Inc()
+		return
+	}
 
-	metrics.WritesLantency.WithLabelValues(operationIndexName, "Ok").Observe(time.Since(writeStart).Seconds())
-	metrics.WritesTotal.WithLabelValues(operationIndexName).Inc()
+	metrics.OperationCatalogRetries.Inc()
+	backoff := operationRetryBaseBackoff * time.Duration(1<<(pending.attempt-1))
+	time.AfterFunc(backoff, func() {
+		select {
+		case <-s.closed:
+			s.seen.Delete(pending.operation.Hash)
+		case s.pending <- pending:
+		default:
+			s.seen.Delete(pending.operation.Hash)
+			metrics.OperationCatalogDropped.Inc()
+		}
+	})
+}
 
-	return nil
+func (s *OperationRepository) newOperation(jaegerSpan *jModel.Span, operationName string) *model.Operation {
+	hash := hashCode(jaegerSpan.Process.ServiceName, operationName)
+
+	spanKind := ""
+	for _, tag := range jaegerSpan.Tags {
+		if tag.Key == "span.kind" {
+			spanKind = tag.AsString()
+		}
+	}
+
+	operation := s.repository.NewEntity()
+	operation.Key = hash
+	operation.ServiceName = jaegerSpan.Process.ServiceName
+	operation.OperationName = operationName
+	operation.SpanKind = spanKind
+	operation.Hash = hash
+	operation.RawNames = true
+
+	return operation
 }
 
 func (s *OperationRepository) GetServices(context context.Context) ([]string, error) {
-	cursor, err := s.repository.Aggregate(context, func(search om.FtAggregateIndex) om.Completed {
-		return search.Query("*").LoadAll().Groupby(1).Property("@service").Reduce("COUNT").Nargs(0).Build()
+	var c []map[string]string
+	err := s.resilience.Do(context, "GetServices", func() error {
+		cursor, err := s.repository.Aggregate(context, func(search om.FtAggregateIndex) om.Completed {
+			return search.Query("*").LoadAll().Groupby(1).Property("@service").Reduce("COUNT").Nargs(0).Build()
+		})
+		if err != nil {
+			return err
+		}
+
+		c, err = cursor.Read(context)
+		return err
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	total := cursor.Total()
-	services := make([]string, total)
-
-	c, err := cursor.Read(context)
-	if err != nil {
-		fmt.Print(err)
-		return nil, err
-	}
+	services := make([]string, len(c))
 
 	for i, s := range c {
-		services[i] = redis.UnTokenization(s["service"])
+		services[i] = s["service"]
 	}
 
 	return services, nil
 }
 
 func (s *OperationRepository) GetOperationsByService(context context.Context, service string) ([]*model.Operation, error) {
-	_, records, err := s.repository.Search(context, func(search om.FtSearchIndex) om.Completed {
-		query := fmt.Sprintf("@service:%s", redis.Tokenization(service))
-		return search.Query(query).Build()
+	var records []*model.Operation
+	err := s.resilience.Do(context, "GetOperationsByService", func() (err error) {
+		_, records, err = s.repository.Search(context, func(search om.FtSearchIndex) om.Completed {
+			query := fmt.Sprintf("@service:{%s}", redis.EscapeTag(service))
+			return search.Query(query).Build()
+		})
+		return err
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	return records, nil
+	// Operations registered by earlier versions, under another hash, are listed once until their entries expire.
+	operations := make([]*model.Operation, 0, len(records))
+	listed := map[[2]string]bool{}
+	for _, record := range records {
+		record.UnescapeNames()
+		if listed[[2]string{record.OperationName, record.SpanKind}] {
+			continue
+		}
+		listed[[2]string{record.OperationName, record.SpanKind}] = true
+		operations = append(operations, record)
+	}
+
+	return operations, nil
 }
 
-func hashCode(jaegerSpan *jModel.Span) string {
+// hashCode identifies the operation of a service. The service name is prefixed with its length, so service a with
+// operation bc and service ab with operation c are told apart.
+func hashCode(serviceName string, operationName string) string {
 	h := fnv.New64a()
-	h.Write([]byte(jaegerSpan.Process.ServiceName))
-	h.Write([]byte(jaegerSpan.OperationName))
+	fmt.Fprintf(h, "%d:%s", len(serviceName), serviceName)
+	h.Write([]byte(operationName))
 	return fmt.Sprintf("%x", h.Sum64())
 }
 
-func setTTL(context context.Context, client rueidis.Client, key string, ttl time.Duration) {
-	client.Do(context, client.B().Expire().Key(key).Seconds(int64(ttl.Seconds())).Build())
+func operationKey(hash string) string {
+	return fmt.Sprintf("%v:%v", operationIndexName, hash)
+}
+
+func expireCommand(client rueidis.Client, key string, ttl time.Duration) om.Completed {
+	return client.B().Expire().Key(key).Seconds(int64(ttl.Seconds())).Build()
 }
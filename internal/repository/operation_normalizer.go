@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+)
+
+// operationNormalizer turns the operation names of the spans into the names registered in the catalog.
+// Rules rewrite the variable parts of the names, e.g. ids in URLs, and the number of distinct names of a service is capped:
+// once a service has reached the cap, its new names are folded into an overflow bucket.
+//
+// The names of each service are tracked by each plugin instance, names unused for longer than the TTL
+// of the catalog entries no longer count towards the cap.
+type operationNormalizer struct {
+	rules    []operationRule
+	max      int
+	overflow string
+	ttl      time.Duration
+	mu       sync.Mutex
+	services map[string]map[string]time.Time
+}
+
+type operationRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+func newOperationNormalizer(config model.Configuration) (*operationNormalizer, error) {
+	n := &operationNormalizer{
+		rules:    make([]operationRule, 0, len(config.OperationRules)),
+		max:      config.MaxOperationsPerService,
+		overflow: config.OperationOverflowName,
+		ttl:      config.RedisTTL,
+		services: map[string]map[string]time.Time{},
+	}
+
+	for _, rule := range config.OperationRules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid operation normalization pattern %s: %w", rule.Pattern, err)
+		}
+		n.rules = append(n.rules, operationRule{pattern: pattern, replacement: rule.Replacement})
+	}
+
+	return n, nil
+}
+
+// Normalize returns the catalog name of the operation of the service.
+func (n *operationNormalizer) Normalize(service string, operation string) string {
+	for _, rule := range n.rules {
+		operation = rule.pattern.ReplaceAllString(operation, rule.replacement)
+	}
+
+	if n.max <= 0 {
+		return operation
+	}
+
+	now := time.Now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	operations, ok := n.services[service]
+	if !ok {
+		operations = map[string]time.Time{}
+		n.services[service] = operations
+	}
+
+	if _, ok := operations[operation]; ok {
+		operations[operation] = now
+		return operation
+	}
+
+	if len(operations) >= n.max {
+		for name, lastSeen := range operations {
+			if now.Sub(lastSeen) > n.ttl {
+				delete(operations, name)
+			}
+		}
+	}
+
+	if len(operations) >= n.max {
+		metrics.OperationOverflow.WithLabelValues(service).Inc()
+		return n.overflow
+	}
+
+	operations[operation] = now
+	return operation
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+)
+
+func TestOperationNormalizerRules(t *testing.T) {
+	normalizer, err := newOperationNormalizer(model.Configuration{OperationRules: []model.OperationRule{
+		{Pattern: "/[0-9]+", Replacement: "/{id}"},
+		{Pattern: "^(GET|POST) ", Replacement: "$1:"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		operation string
+		want      string
+	}{
+		{operation: "GET /users/42", want: "GET:/users/{id}"},
+		{operation: "POST /users/42/orders/7", want: "POST:/users/{id}/orders/{id}"},
+		{operation: "consume", want: "consume"},
+	}
+
+	for _, test := range tests {
+		if got := normalizer.Normalize("api", test.operation); got != test.want {
+			t.Errorf("normalized %q to %q, want %q", test.operation, got, test.want)
+		}
+	}
+}
+
+func TestNewOperationNormalizerRejectsInvalidPattern(t *testing.T) {
+	if _, err := newOperationNormalizer(model.Configuration{OperationRules: []model.OperationRule{{Pattern: "("}}}); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestOperationNormalizerCapsOperationsPerService(t *testing.T) {
+	normalizer, err := newOperationNormalizer(model.Configuration{MaxOperationsPerService: 2, OperationOverflowName: "other", RedisTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if got := normalizer.Normalize("api", fmt.Sprintf("op-%d", i)); got != fmt.Sprintf("op-%d", i) {
+			t.Errorf("operation under the cap normalized to %q", got)
+		}
+	}
+	if got := normalizer.Normalize("api", "op-2"); got != "other" {
+		t.Errorf("operation over the cap normalized to %q, want other", got)
+	}
+	if got := normalizer.Normalize("api", "op-0"); got != "op-0" {
+		t.Errorf("known operation normalized to %q", got)
+	}
+	if got := normalizer.Normalize("web", "op-2"); got != "op-2" {
+		t.Errorf("operation of another service normalized to %q", got)
+	}
+
+	// Operations unused for longer than the catalog TTL no longer count towards the cap.
+	normalizer.services["api"]["op-1"] = time.Now().Add(-2 * time.Hour)
+	if got := normalizer.Normalize("api", "op-2"); got != "op-2" {
+		t.Errorf("operation replacing an expired one normalized to %q", got)
+	}
+}
@@ -0,0 +1,24 @@
+package repository
+
+import "testing"
+
+func TestHashCodeSeparatesServiceAndOperation(t *testing.T) {
+	tests := []struct {
+		service   string
+		operation string
+	}{
+		{"a", "bc"},
+		{"ab", "c"},
+		{"abc", ""},
+		{"", "abc"},
+	}
+
+	hashes := map[string]int{}
+	for i, test := range tests {
+		hash := hashCode(test.service, test.operation)
+		if j, ok := hashes[hash]; ok {
+			t.Errorf("%+v and %+v share the hash %s", tests[j], test, hash)
+		}
+		hashes[hash] = i
+	}
+}
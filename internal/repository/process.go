@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/encryption"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/rueian/rueidis"
+	"github.com/rueian/rueidis/om"
+)
+
+const (
+	processIndexName = "processes"
+	// processSweepInterval is how often the records due to be written again are forgotten.
+	processSweepInterval = time.Minute
+	// processSearchLimit bounds the records a tag search matches spans through, their hashes being joined into the query
+	// of the spans. Past it, the spans referencing the other records holding the tag are not found.
+	processSearchLimit = 1000
+)
+
+// processStore keeps each distinct process once, the spans referencing it through its hash instead of holding its tags.
+// Records live twice as long as spans and are written again once per span TTL, so they outlive the spans referencing them.
+// Records are indexed by their tag pairs, so the spans referencing a record are found by its process tags.
+// The hashes of the records written are kept until their write is due again, so they are bounded by the processes
+// seen during one TTL.
+type processStore struct {
+	client     rueidis.Client
+	repository *om.JSONRepository[model.ProcessRecord]
+	keyring    *encryption.Keyring
+	ttl        time.Duration
+	written    sync.Map
+	mu         sync.Mutex
+	lastSweep  time.Time
+}
+
+func newProcessStore(client rueidis.Client, keyring *encryption.Keyring, ttl time.Duration) *processStore {
+	repository, _ := om.NewJSONRepository(processIndexName, model.ProcessRecord{}, client).(*om.JSONRepository[model.ProcessRecord])
+	return &processStore{client: client, repository: repository, keyring: keyring, ttl: ttl, lastSweep: time.Now()}
+}
+
+// processSchema declares the process record index.
+var processSchema = indexSchema{
+	{path: "$.serviceName", name: "serviceName", kind: "TAG", exact: true},
+	{path: "$.tagPairs[0:]", name: "tagPair", kind: "TAG", exact: true},
+}
+
+// intern moves the tags of the process of the span document into a record, referenced by the hash the span now holds.
+func (p *processStore) intern(span *model.Span) *model.ProcessRecord {
+	span.ProcessHash = processHash(span.Process)
+	record := &model.ProcessRecord{ServiceName: span.Process.ServiceName, Tags: span.Process.Tags}
+
+	// Tags that cannot be converted back are searched as they are converted.
+	tags, _ := model.ConvertKeyValuesToJaeger(span.Process.Tags)
+	record.TagPairs = model.KeyValuePairs(tags)
+
+	span.Process.Tags = nil
+	return record
+}
+
+// hashes returns the hashes of the records matching the query, at most processSearchLimit of them.
+func (p *processStore) hashes(context context.Context, query string) ([]string, error) {
+	cmd := p.client.B().FtSearch().Index(p.repository.IndexName()).Query(query).Nocontent().Limit().OffsetNum(0, processSearchLimit).Build()
+	reply, err := p.client.Do(context, cmd).ToArray()
+	if err != nil {
+		return nil, err
+	}
+
+	// The reply holds the number of matches followed by the key of each record.
+	hashes := make([]string, 0, len(reply))
+	for _, message := range reply[1:] {
+		key, err := message.ToString()
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, strings.TrimPrefix(key, processIndexName+":"))
+	}
+	return hashes, nil
+}
+
+// due returns the records, by hash, that were not written during the last TTL.
+func (p *processStore) due(records map[string]*model.ProcessRecord) map[string]*model.ProcessRecord {
+	now := time.Now()
+	due := map[string]*model.ProcessRecord{}
+	for hash, record := range records {
+		if writeAt, ok := p.written.Load(hash); !ok || !now.Before(writeAt.(time.Time)) {
+			due[hash] = record
+		}
+	}
+	return due
+}
+
+// write stores the records, by hash.
+func (p *processStore) write(context context.Context, records map[string]*model.ProcessRecord) error {
+	now := time.Now()
+
+	hashes := make([]string, 0, len(records))
+	cmds := make(rueidis.Commands, 0, len(records))
+	for hash, record := range records {
+		stored := *record
+		if p.keyring != nil {
+			if err := p.keyring.SealProcess(&stored, hash); err != nil {
+				return err
+			}
+		}
+
+		hashes = append(hashes, hash)
+		cmds = append(cmds,
+			p.client.B().JsonSet().Key(processKey(hash)).Path("$").Value(rueidis.JSON(stored)).Build(),
+			expireCommand(p.client, processKey(hash), 2*p.ttl))
+	}
+
+	resps := p.client.DoMulti(context, cmds...)
+	for i, hash := range hashes {
+		for _, resp := range resps[2*i : 2*i+2] {
+			if err := resp.Error(); err != nil {
+				return err
+			}
+		}
+		p.written.Store(hash, now.Add(p.ttl))
+	}
+
+	p.sweep(now)
+	return nil
+}
+
+// sweep forgets the records due to be written again, as they are no different from records never written.
+func (p *processStore) sweep(now time.Time) {
+	p.mu.Lock()
+	if now.Sub(p.lastSweep) < processSweepInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.lastSweep = now
+	p.mu.Unlock()
+
+	p.written.Range(func(hash, writeAt interface{}) bool {
+		if !now.Before(writeAt.(time.Time)) {
+			p.written.Delete(hash)
+		}
+		return true
+	})
+}
+
+// hydrate restores the process tags of the span documents referencing a record, fetching each record once.
+// Spans whose record cannot be read are given a warning.
+func (p *processStore) hydrate(context context.Context, spans []*model.Span) error {
+	hashes := []string{}
+	records := map[string]*model.ProcessRecord{}
+	for _, span := range spans {
+		if _, ok := records[span.ProcessHash]; span.ProcessHash == "" || ok {
+			continue
+		}
+		records[span.ProcessHash] = nil
+		hashes = append(hashes, span.ProcessHash)
+	}
+
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	cmds := make(rueidis.Commands, 0, len(hashes))
+	for _, hash := range hashes {
+		cmds = append(cmds, p.client.B().JsonGet().Key(processKey(hash)).Build())
+	}
+	resps := p.client.DoMulti(context, cmds...)
+
+	failures := map[string]error{}
+	for i := range hashes {
+		data, err := resps[i].ToString()
+		if rueidis.IsRedisNil(err) {
+			failures[hashes[i]] = fmt.Errorf("process record %s expired", hashes[i])
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		record := &model.ProcessRecord{}
+		if err := json.Unmarshal([]byte(data), record); err != nil {
+			failures[hashes[i]] = err
+			continue
+		}
+
+		if p.keyring != nil {
+			if err := p.keyring.OpenProcess(record, hashes[i]); err != nil {
+				failures[hashes[i]] = err
+				continue
+			}
+		}
+		records[hashes[i]] = record
+	}
+
+	for _, span := range spans {
+		if span.ProcessHash == "" {
+			continue
+		}
+
+		if err, ok := failures[span.ProcessHash]; ok {
+			span.Warnings = append(span.Warnings, fmt.Sprintf("unable to read process tags: %v", err))
+			continue
+		}
+		span.Process.Tags = records[span.ProcessHash].Tags
+	}
+	return nil
+}
+
+// processHash derives the hash of the process from its service name and tags, so identical processes share their record.
+func processHash(process model.Process) string {
+	h := fnv.New64a()
+	h.Write([]byte(rueidis.JSON(process)))
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+func processKey(hash string) string {
+	return fmt.Sprintf("%v:%v", processIndexName, hash)
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/golang/mock/gomock"
+	jModel "github.com/jaegertracing/jaeger/model"
+	"github.com/rueian/rueidis"
+	"github.com/rueian/rueidis/mock"
+)
+
+// TestInternKeepsProcessTagsSearchable checks interned process tags are only searched through the record of the process.
+func TestInternKeepsProcessTagsSearchable(t *testing.T) {
+	repository := &SpanRepository{config: model.Configuration{ProcessInterning: true}, processes: &processStore{}}
+
+	jSpan := &jModel.Span{
+		TraceID: jModel.NewTraceID(0, 1),
+		SpanID:  jModel.NewSpanID(1),
+		Tags:    []jModel.KeyValue{jModel.String("http.method", "GET")},
+		Process: jModel.NewProcess("api", []jModel.KeyValue{jModel.String("hostname", "node-1"), jModel.Int64("pid", 42)}),
+	}
+
+	span := repository.newSpan(jSpan, "GET /users")
+	record := repository.processes.intern(span)
+
+	if want := []string{"http.method=GET"}; !reflect.DeepEqual(span.TagPairs, want) {
+		t.Errorf("span tag pairs %v, want %v", span.TagPairs, want)
+	}
+	if want := []string{"hostname=node-1", "pid=42"}; !reflect.DeepEqual(record.TagPairs, want) {
+		t.Errorf("record tag pairs %v, want %v", record.TagPairs, want)
+	}
+	if span.ProcessHash == "" || len(span.Process.Tags) != 0 {
+		t.Errorf("span holds process tags %v with hash %q", span.Process.Tags, span.ProcessHash)
+	}
+}
+
+func TestBuildQueryFilterMatchesProcessRecords(t *testing.T) {
+	parameters := model.TraceQueryParameters{ServiceName: "api", Tags: map[string]string{"hostname": "node-1", "http.method": "GET"}}
+
+	query := buildQueryFilter(parameters, nil, nil, map[string][]string{"hostname": {"a1", "b2"}})
+
+	if !strings.Contains(query, `((@tagPair:{hostname\=node\-1})|(@processHash:{a1|b2}))`) {
+		t.Errorf("query %s does not match the spans referencing the records holding hostname", query)
+	}
+	if !strings.Contains(query, ` @tagPair:{http\.method\=GET}`) {
+		t.Errorf("query %s does not match the spans holding http.method", query)
+	}
+}
+
+// TestNewProcessStoreIndexesRecords checks the repository of the records can be created, it requires a key and a version field.
+func TestNewProcessStoreIndexesRecords(t *testing.T) {
+	processes := newProcessStore(mock.NewClient(gomock.NewController(t)), nil, time.Hour)
+
+	if name := processes.repository.IndexName(); name != "jsonidx:processes" {
+		t.Errorf("index name %q, want jsonidx:processes", name)
+	}
+}
+
+// TestProcessStoreForgetsDueRecords checks the records written are only kept until their write is due again.
+func TestProcessStoreForgetsDueRecords(t *testing.T) {
+	processes := &processStore{ttl: time.Hour}
+	now := time.Now()
+
+	processes.written.Store("due", now.Add(-time.Second))
+	processes.written.Store("recent", now.Add(time.Hour))
+
+	processes.sweep(now)
+
+	if _, ok := processes.written.Load("due"); ok {
+		t.Errorf("record due to be written again is still kept")
+	}
+	if _, ok := processes.written.Load("recent"); !ok {
+		t.Errorf("record written during the last TTL was forgotten")
+	}
+
+	// Records are not swept again before processSweepInterval elapsed.
+	processes.written.Store("due", now.Add(-time.Second))
+	processes.sweep(now.Add(processSweepInterval / 2))
+	if _, ok := processes.written.Load("due"); !ok {
+		t.Errorf("records swept before the sweep interval elapsed")
+	}
+}
+
+// TestHydrateRestoresProcessTags checks the process tags are read from the records the spans reference,
+// and spans whose record expired are given a warning.
+func TestHydrateRestoresProcessTags(t *testing.T) {
+	ctx := context.Background()
+	client := mock.NewClient(gomock.NewController(t))
+	processes := newProcessStore(client, nil, time.Hour)
+
+	record := `{"serviceName":"api","tags":[{"key":"hostname","type":"string","value":"node-1"}]}`
+	client.EXPECT().DoMulti(ctx, mock.Match("JSON.GET", "processes:a1"), mock.Match("JSON.GET", "processes:b2")).
+		Return([]rueidis.RedisResult{mock.Result(mock.RedisString(record)), mock.Result(mock.RedisNil())})
+
+	spans := []*model.Span{
+		{SpanID: "1", ProcessHash: "a1", Process: model.Process{ServiceName: "api"}},
+		{SpanID: "2", ProcessHash: "b2", Process: model.Process{ServiceName: "api"}},
+		{SpanID: "3", ProcessHash: "a1", Process: model.Process{ServiceName: "api"}},
+	}
+	if err := processes.hydrate(ctx, spans); err != nil {
+		t.Fatalf("hydrate() error = %v", err)
+	}
+
+	for _, span := range []*model.Span{spans[0], spans[2]} {
+		if len(span.Process.Tags) != 1 || span.Process.Tags[0].Key != "hostname" {
+			t.Errorf("span %s process tags %v, want hostname", span.SpanID, span.Process.Tags)
+		}
+	}
+	if len(spans[1].Warnings) != 1 || !strings.Contains(spans[1].Warnings[0], "expired") {
+		t.Errorf("span 2 warnings %v, want the record expired", spans[1].Warnings)
+	}
+}
+
+// TestProcessHashesAreCapped checks a tag search matches spans through at most processSearchLimit records,
+// all of them joined into the query of the spans.
+func TestProcessHashesAreCapped(t *testing.T) {
+	ctx := context.Background()
+	client := mock.NewClient(gomock.NewController(t))
+	processes := newProcessStore(client, nil, time.Hour)
+
+	reply := []rueidis.RedisMessage{mock.RedisInt64(2 * processSearchLimit)}
+	for i := 0; i < processSearchLimit; i++ {
+		reply = append(reply, mock.RedisString(processKey(fmt.Sprintf("%016x", i))))
+	}
+	query := "@serviceName:{api} @tagPair:{hostname\\=node\\-1}"
+	client.EXPECT().Do(ctx, mock.Match("FT.SEARCH", "jsonidx:processes", query, "NOCONTENT", "LIMIT", "0", strconv.Itoa(processSearchLimit))).
+		Return(mock.Result(mock.RedisArray(reply...)))
+
+	hashes, err := processes.hashes(ctx, query)
+	if err != nil {
+		t.Fatalf("hashes() error = %v", err)
+	}
+	if len(hashes) != processSearchLimit {
+		t.Fatalf("%d hashes, want %d", len(hashes), processSearchLimit)
+	}
+
+	parameters := model.TraceQueryParameters{ServiceName: "api", Tags: map[string]string{"hostname": "node-1"}}
+	filter := buildQueryFilter(parameters, nil, nil, map[string][]string{"hostname": hashes})
+	if !strings.Contains(filter, "@processHash:{"+strings.Join(hashes, "|")+"}") {
+		t.Errorf("query does not match the spans referencing the %d records", len(hashes))
+	}
+}
@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/rueian/rueidis"
+)
+
+const (
+	breakerClosed = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// ErrCircuitOpen is returned without calling Redis while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("redis circuit breaker is open")
+
+// retriableRedisErrors are the prefixes of the errors Redis replies while it cannot serve a command for a short while.
+var retriableRedisErrors = []string{"LOADING", "TRYAGAIN", "BUSY", "MASTERDOWN", "CLUSTERDOWN", "READONLY"}
+
+// Resilience retries the Redis calls failing with transient errors, with jittered exponential backoff,
+// and stops calling Redis for a while once calls kept failing, so a struggling Redis is given time to recover.
+// It is shared by the repositories, so the breaker reflects the health of Redis as a whole.
+type Resilience struct {
+	maxAttempts  int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	threshold    int
+	openDuration time.Duration
+	mu           sync.Mutex
+	state        int
+	failures     int
+	openedAt     time.Time
+	trial        bool
+}
+
+func NewResilience(config model.Configuration) *Resilience {
+	maxAttempts := config.RetryMaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	metrics.CircuitBreakerState.Set(breakerClosed)
+
+	return &Resilience{
+		maxAttempts:  maxAttempts,
+		backoff:      config.RetryBackoff,
+		maxBackoff:   config.RetryMaxBackoff,
+		threshold:    config.BreakerFailures,
+		openDuration: config.BreakerOpenDuration,
+	}
+}
+
+// Do calls call until it succeeds, fails with an error that is not transient, runs out of attempts
+// or would retry past the deadline of the context. operation labels the metrics.
+func (r *Resilience) Do(context context.Context, operation string, call func() error) error {
+	if !r.allow() {
+		metrics.CircuitBreakerRejected.WithLabelValues(operation).Inc()
+		return ErrCircuitOpen
+	}
+
+	var err error
+	for attempt := 0; attempt < r.maxAttempts; attempt++ {
+		if attempt > 0 {
+			if !r.sleep(context, attempt) {
+				break
+			}
+			metrics.RedisRetries.WithLabelValues(operation).Inc()
+		}
+
+		err = call()
+		if !IsRetriable(err) {
+			break
+		}
+	}
+
+	r.record(IsRetriable(err))
+	return err
+}
+
+// Healthy reports whether the circuit breaker lets calls through.
+func (r *Resilience) Healthy() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.state != breakerOpen || time.Since(r.openedAt) >= r.openDuration
+}
+
+// sleep waits for the jittered backoff of the attempt, it returns false when the context ends first.
+func (r *Resilience) sleep(context context.Context, attempt int) bool {
+	backoff := r.backoff << (attempt - 1)
+	if backoff > r.maxBackoff || backoff <= 0 {
+		backoff = r.maxBackoff
+	}
+	backoff = time.Duration(rand.Int63n(int64(backoff) + 1))
+
+	if deadline, ok := context.Deadline(); ok && time.Until(deadline) < backoff {
+		return false
+	}
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-context.Done():
+		return false
+	}
+}
+
+// allow reports whether a call may go through. Once the breaker has been open for long enough,
+// a single trial call is let through, closing the breaker when it succeeds.
+func (r *Resilience) allow() bool {
+	if r.threshold <= 0 {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch r.state {
+	case breakerOpen:
+		if time.Since(r.openedAt) < r.openDuration {
+			return false
+		}
+		r.setState(breakerHalfOpen)
+		r.trial = true
+		return true
+	case breakerHalfOpen:
+		if r.trial {
+			return false
+		}
+		r.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (r *Resilience) record(failed bool) {
+	if r.threshold <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.trial = false
+
+	if !failed {
+		r.failures = 0
+		r.setState(breakerClosed)
+		return
+	}
+
+	r.failures++
+	if r.state == breakerHalfOpen || r.failures >= r.threshold {
+		r.openedAt = time.Now()
+		r.setState(breakerOpen)
+	}
+}
+
+func (r *Resilience) setState(state int) {
+	r.state = state
+	metrics.CircuitBreakerState.Set(float64(state))
+}
+
+// IsUnavailable reports whether err means Redis could not take the write for now, rather than that it rejected it:
+// the error is transient, the call timed out, the circuit breaker is open or the ingestion stream is full.
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrStreamFull) || errors.Is(err, context.DeadlineExceeded) || IsRetriable(err)
+}
+
+// IsRetriable reports whether err is transient: Redis is loading, failing over or busy, or the connection was lost.
+func IsRetriable(err error) bool {
+	if err == nil || rueidis.IsRedisNil(err) {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var redisErr *rueidis.RedisError
+	if errors.As(err, &redisErr) {
+		for _, prefix := range retriableRedisErrors {
+			if strings.HasPrefix(redisErr.Error(), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, rueidis.ErrClosing)
+}
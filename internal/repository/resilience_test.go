@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+)
+
+func TestIsRetriable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: nil},
+		{err: errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{err: context.Canceled},
+		{err: context.DeadlineExceeded},
+		{err: io.EOF, want: true},
+		{err: fmt.Errorf("write: %w", syscall.ECONNRESET), want: true},
+		{err: syscall.ECONNREFUSED, want: true},
+	}
+
+	for _, test := range tests {
+		if got := IsRetriable(test.err); got != test.want {
+			t.Errorf("IsRetriable(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestIsUnavailable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: nil},
+		{err: errors.New("rejected")},
+		{err: ErrCircuitOpen, want: true},
+		{err: ErrStreamFull, want: true},
+		{err: context.DeadlineExceeded, want: true},
+		{err: io.EOF, want: true},
+	}
+
+	for _, test := range tests {
+		if got := IsUnavailable(test.err); got != test.want {
+			t.Errorf("IsUnavailable(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestResilienceRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		want     error
+	}{
+		{name: "success", errs: []error{nil}, attempts: 1},
+		{name: "transient error", errs: []error{io.EOF, io.EOF, nil}, attempts: 3},
+		{name: "error that is not transient", errs: []error{errors.New("rejected")}, attempts: 1, want: errors.New("rejected")},
+		{name: "out of attempts", errs: []error{io.EOF, io.EOF, io.EOF, nil}, attempts: 3, want: io.EOF},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewResilience(model.Configuration{RetryMaxAttempts: 3, RetryBackoff: time.Millisecond, RetryMaxBackoff: time.Millisecond})
+
+			attempts := 0
+			err := r.Do(context.Background(), "test", func() error {
+				attempts++
+				return test.errs[attempts-1]
+			})
+
+			if attempts != test.attempts {
+				t.Errorf("called %d times, want %d", attempts, test.attempts)
+			}
+			if fmt.Sprint(err) != fmt.Sprint(test.want) {
+				t.Errorf("returned %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestResilienceStopsRetryingPastTheDeadline(t *testing.T) {
+	r := NewResilience(model.Configuration{RetryMaxAttempts: 5, RetryBackoff: time.Hour, RetryMaxBackoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	attempts := 0
+	r.Do(ctx, "test", func() error {
+		attempts++
+		return io.EOF
+	})
+
+	if attempts != 1 || time.Since(start) > time.Second {
+		t.Errorf("called %d times in %v, want a single call", attempts, time.Since(start))
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	r := NewResilience(model.Configuration{RetryMaxAttempts: 1, BreakerFailures: 2, BreakerOpenDuration: 20 * time.Millisecond})
+	failing := func() error { return io.EOF }
+	succeeding := func() error { return nil }
+
+	r.Do(context.Background(), "test", failing)
+	if !r.Healthy() {
+		t.Fatal("breaker opened before reaching the threshold")
+	}
+	r.Do(context.Background(), "test", failing)
+	if r.Healthy() {
+		t.Fatal("breaker still closed after reaching the threshold")
+	}
+
+	called := false
+	if err := r.Do(context.Background(), "test", func() error { called = true; return nil }); err != ErrCircuitOpen || called {
+		t.Fatalf("open breaker returned %v and called Redis: %v", err, called)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := r.Do(context.Background(), "test", failing); err != io.EOF {
+		t.Fatalf("trial call returned %v", err)
+	}
+	if err := r.Do(context.Background(), "test", succeeding); err != ErrCircuitOpen {
+		t.Fatalf("breaker did not open again after a failed trial call, returned %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := r.Do(context.Background(), "test", succeeding); err != nil {
+		t.Fatalf("trial call returned %v", err)
+	}
+	if !r.Healthy() {
+		t.Error("breaker still open after a successful trial call")
+	}
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/rueian/rueidis"
+	"github.com/rueian/rueidis/om"
+)
+
+// spanWriteScript stores a span document and assigns its TTL in one atomic call.
+// It replies 0 without touching anything when the span is already stored.
+//
+//	KEYS[1]: span key
+//	ARGV[1]: span document
+//	ARGV[2]: TTL in seconds
+const spanWriteScript = `
+if not redis.call('JSON.SET', KEYS[1], '$', ARGV[1], 'NX') then
+  return 0
+end
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+return 1
+`
+
+// script is a Lua script loaded into the server script cache at startup,
+// so it can be pipelined through EVALSHA. Scripts touch a single key, so they run in cluster mode too; nodes that
+// did not load the script reply NOSCRIPT and the call is sent again through EVAL.
+type script struct {
+	body string
+	sha  string
+}
+
+func loadScript(context context.Context, client rueidis.Client, body string) (*script, error) {
+	sha, err := client.Do(context, client.B().ScriptLoad().Script(body).Build()).ToString()
+	if err != nil {
+		return nil, err
+	}
+
+	return &script{body: body, sha: sha}, nil
+}
+
+// loadWriteScript loads spanWriteScript when scripting is enabled. It returns nil when scripting is disabled
+// or the server denies it, spans being written through pipelined commands then.
+func loadWriteScript(logger hclog.Logger, client rueidis.Client, config model.Configuration) *script {
+	if !config.RedisScripting {
+		return nil
+	}
+
+	writeScript, err := loadScript(context.TODO(), client, spanWriteScript)
+	if err != nil {
+		logger.Warn("unable to load span write script, falling back to pipelined commands", "err", err)
+		return nil
+	}
+	return writeScript
+}
+
+func (s *script) evalsha(client rueidis.Client, keys []string, args []string) om.Completed {
+	return client.B().Evalsha().Sha1(s.sha).Numkeys(int64(len(keys))).Key(keys...).Arg(args...).Build()
+}
+
+func (s *script) eval(client rueidis.Client, keys []string, args []string) om.Completed {
+	return client.B().Eval().Script(s.body).Numkeys(int64(len(keys))).Key(keys...).Arg(args...).Build()
+}
+
+func isNoScript(err error) bool {
+	if err, ok := err.(*rueidis.RedisError); ok {
+		return err.IsNoScript()
+	}
+	return false
+}
@@ -3,11 +3,15 @@ package repository
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/nicolastakashi/jaeger-redisearch/internal/encryption"
 	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
 	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/redaction"
 	"github.com/nicolastakashi/jaeger-redisearch/internal/redis"
 
 	"github.com/hashicorp/go-hclog"
@@ -16,102 +20,514 @@ import (
 	"github.com/rueian/rueidis/om"
 )
 
-const spanIndexName = "spans"
+const (
+	spanIndexName    = "spans"
+	maxMergeAttempts = 3
+
+	// StorageJSON stores every field of the spans in their documents.
+	StorageJSON = "json"
+	// StorageCompact stores the spans as compressed protobuf blobs, their documents only holding the fields searches need.
+	StorageCompact = "compact"
+)
 
 type SpanRepository struct {
 	logger     hclog.Logger
 	repository om.Repository[model.Span]
+	operations *OperationRepository
+	script     *script
+	guardrails guardrails
+	redactor   *redaction.Redactor
+	keyring    *encryption.Keyring
+	durability *durability
+	resilience *Resilience
+	processes  *processStore
 	client     rueidis.Client
 	config     model.Configuration
 }
 
-func NewSpanRepository(logger hclog.Logger, redisClient rueidis.Client, config model.Configuration) (*SpanRepository, error) {
+func NewSpanRepository(logger hclog.Logger, redisClient rueidis.Client, config model.Configuration, operationRepository *OperationRepository, resilience *Resilience) (*SpanRepository, error) {
 	repository := om.NewJSONRepository(spanIndexName, model.Span{}, redisClient)
-	if _, ok := repository.(*om.JSONRepository[model.Span]); ok {
-		createSpanIndex(repository)
+	if jsonRepository, ok := repository.(*om.JSONRepository[model.Span]); ok {
+		if err := ensureIndex(context.TODO(), redisClient, jsonRepository.IndexName(), spanIndexName, spanSchema); err != nil {
+			return nil, fmt.Errorf("unable to create span index: %w", err)
+		}
+	}
+
+	redactor, err := redaction.New(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyring *encryption.Keyring
+	if config.EncryptionKeyFile != "" {
+		keyring, err = encryption.LoadKeyring(config.EncryptionKeyFile, config.EncryptionKeyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	durability, err := newDurability(context.TODO(), redisClient, config)
+	if err != nil {
+		return nil, err
 	}
+
+	processes := newProcessStore(redisClient, keyring, config.RedisTTL)
+	if err := ensureIndex(context.TODO(), redisClient, processes.repository.IndexName(), processIndexName, processSchema); err != nil {
+		return nil, fmt.Errorf("unable to create process index: %w", err)
+	}
+
+	if config.SpanStorage != StorageJSON && config.SpanStorage != StorageCompact {
+		return nil, fmt.Errorf("invalid span storage mode: %s", config.SpanStorage)
+	}
+
 	return &SpanRepository{
 		logger:     logger,
 		repository: repository,
+		operations: operationRepository,
+		script:     loadWriteScript(logger, redisClient, config),
+		guardrails: newGuardrails(config),
+		redactor:   redactor,
+		keyring:    keyring,
+		durability: durability,
+		resilience: resilience,
+		processes:  processes,
 		client:     redisClient,
 		config:     config,
 	}, nil
 }
 
-func createSpanIndex(repository om.Repository[model.Span]) {
-	repository.CreateIndex(context.TODO(), func(schema om.FtCreateSchema) om.Completed {
-		text := schema.FieldName("$.traceID").As("traceID").Text()
-		text = text.FieldName("$.spanID").As("spanID").Text()
-		text = text.FieldName("$.operationName").As("operationName").Text()
+// spanSchema declares the span index. Identifiers are matched exactly, earlier span indexes declared them as TEXT fields
+// whose words match any name holding them. Tag values are stored with their type, and numbers cannot be indexed
+// as TAG fields, so values are only indexed within the tag pairs: indexing values apart from their keys
+// matched a search for error=true against any span with an error tag and any tag valued true.
+var spanSchema = indexSchema{
+	{path: "$.traceID", name: "traceID", kind: "TAG", exact: true},
+	{path: "$.operationName", name: "operationName", kind: "TAG", exact: true},
+	{path: "$.catalogName", name: "catalogName", kind: "TAG", exact: true},
+	{path: "$.process.serviceName", name: "processServiceName", kind: "TAG", exact: true},
+	{path: "$.processHash", name: "processHash", kind: "TAG", exact: true},
+	{path: "$.spanID", name: "spanID", kind: "TEXT"},
+	{path: "$.process.tags[0:].key", name: "processTagKey", kind: "TAG"},
+	{path: "$.process.tags[0:].type", name: "processTagType", kind: "TAG"},
+	{path: "$.tags[0:].key", name: "tagKey", kind: "TAG"},
+	{path: "$.tags[0:].type", name: "tagType", kind: "TAG"},
+	{path: "$.tagPairs[0:]", name: "tagPair", kind: "TAG", exact: true},
+	{path: "$.references[0:].refType", name: "refType", kind: "TAG"},
+	{path: "$.references[0:].traceID", name: "refTraceID", kind: "TAG"},
+	{path: "$.references[0:].spanID", name: "refSpanID", kind: "TAG"},
+	{path: "$.logs[0:].fields[0:].key", name: "logFieldKey", kind: "TAG"},
+	{path: "$.logs[0:].fields[0:].type", name: "logFieldType", kind: "TAG"},
+	{path: "$.logs[0:].timestamp", name: "logTimestamp", kind: "NUMERIC"},
+	{path: "$.startTime", name: "startTime", kind: "NUMERIC"},
+	{path: "$.flags", name: "flags", kind: "NUMERIC"},
+	{path: "$.duration", name: "duration", kind: "NUMERIC"},
+}
 
-		text = text.FieldName("$.process.serviceName").As("processServiceName").Text()
+func (s *SpanRepository) Write(context context.Context, jSpan *jModel.Span) error {
+	return s.WriteBatch(context, []*jModel.Span{jSpan})[0]
+}
 
-		tag := text.FieldName("$.process.tags[0:].key").As("processTagKey").Tag()
-		tag = tag.FieldName("$.process.tags[0:].type").As("processTagType").Tag()
-		tag = tag.FieldName("$.process.tags[0:].value").As("processTagValue").Tag()
+// WriteBatch stores all spans and their TTL using a single pipelined round trip.
+// Spans are limited by the configured guardrails first, spans over the trace limit are dropped without error.
+// The operations of the spans written are registered in the catalog in the background.
+// The returned slice holds the result of each span, in the same order as jSpans.
+// With fire-and-forget durability, spans are written in the background and only conversion errors are returned.
+func (s *SpanRepository) WriteBatch(context context.Context, jSpans []*jModel.Span) []error {
+	return s.writeBatch(context, jSpans, true)
+}
 
-		tag = tag.FieldName("$.tags[0:].key").As("tagKey").Tag()
-		tag = tag.FieldName("$.tags[0:].type").As("tagType").Tag()
-		tag = tag.FieldName("$.tags[0:].value").As("tagValue").Tag()
+// RewriteBatch stores spans WriteBatch was given before, replayed from the spill file or redelivered by the stream.
+// They were already counted against the trace limit, so they are admitted without counting them again.
+func (s *SpanRepository) RewriteBatch(context context.Context, jSpans []*jModel.Span) []error {
+	return s.writeBatch(context, jSpans, false)
+}
 
-		tag = tag.FieldName("$.mTags[0:].key").As("mTagKey").Tag()
-		tag = tag.FieldName("$.mTags[0:].type").As("mTagType").Tag()
-		tag = tag.FieldName("$.mTags[0:].value").As("mTagValue").Tag()
+func (s *SpanRepository) writeBatch(context context.Context, jSpans []*jModel.Span, count bool) []error {
+	for _, jSpan := range jSpans {
+		s.guardrails.limitSpan(jSpan)
+	}
 
-		tag = tag.FieldName("$.references[0:].refType").As("refType").Tag()
-		tag = tag.FieldName("$.references[0:].traceID").As("refTraceID").Tag()
-		tag = tag.FieldName("$.references[0:].spanID").As("refSpanID").Tag()
+	within := admitAll(len(jSpans))
+	if count {
+		within = s.guardrails.admitSpans(context, s.client, jSpans, int64(s.config.RedisTTL.Seconds()))
+	}
 
-		tag = tag.FieldName("$.logs[0:].fields[0:].key").As("logFieldKey").Tag()
-		tag = tag.FieldName("$.logs[0:].fields[0:].type").As("logFieldType").Tag()
-		tag = tag.FieldName("$.logs[0:].fields[0:].value").As("logFieldValue").Tag()
+	errs := make([]error, len(jSpans))
+	records := map[string]*model.ProcessRecord{}
+	admitted := make([]*jModel.Span, 0, len(jSpans))
+	documents := make([]*model.Span, 0, len(jSpans))
+	operations := make([]string, 0, len(jSpans))
+	owners := make([]int, 0, len(jSpans))
+	for i, ok := range within {
+		if !ok {
+			continue
+		}
 
-		numeric := tag.FieldName("$.logs[0:].timestamp").As("logTimestamp").Numeric()
-		numeric = numeric.FieldName("$.startTime").As("startTime").Numeric()
-		numeric = numeric.FieldName("$.flags").As("flags").Numeric()
-		numeric = numeric.FieldName("$.duration").As("duration").Numeric()
+		operation := s.operations.Normalize(jSpans[i])
+		document, err := s.newDocument(jSpans[i], operation, records)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
 
-		return numeric.Build()
-	})
+		admitted = append(admitted, jSpans[i])
+		documents = append(documents, document)
+		operations = append(operations, operation)
+		owners = append(owners, i)
+	}
+
+	if s.durability.mode == DurabilityFireAndForget {
+		s.durability.background(func() {
+			s.storeInBackground(admitted, documents, operations, records)
+		})
+		return errs
+	}
+
+	for i, err := range s.store(context, admitted, documents, operations, records) {
+		errs[owners[i]] = err
+	}
+	return errs
 }
 
-func (s *SpanRepository) Write(context context.Context, jSpan *jModel.Span) error {
+// Close waits for the spans written in the background.
+func (s *SpanRepository) Close() error {
+	s.durability.Close()
+	return nil
+}
+
+func (s *SpanRepository) storeInBackground(jSpans []*jModel.Span, documents []*model.Span, operations []string, records map[string]*model.ProcessRecord) {
+	for i, err := range s.store(context.Background(), jSpans, documents, operations, records) {
+		if err != nil {
+			s.logger.Error("error to write span", "traceID", jSpans[i].TraceID.String(), "err", err)
+		}
+	}
+}
+
+// store writes the documents of the spans, operations holding the catalog names of their operations
+// and records the processes the documents reference.
+func (s *SpanRepository) store(context context.Context, jSpans []*jModel.Span, documents []*model.Span, operations []string, records map[string]*model.ProcessRecord) []error {
 	writeStart := time.Now()
 
-	span := s.repository.NewEntity()
+	errs := make([]error, len(documents))
+	stored := []int{}
+
+	// Process records are written first, so documents never reference a missing record.
+	if due := s.processes.due(records); len(due) > 0 {
+		err := s.resilience.Do(context, "process", func() error {
+			return s.processes.write(context, due)
+		})
+		if err != nil {
+			for i := range errs {
+				errs[i] = err
+			}
+			s.observe(writeStart, errs)
+			return errs
+		}
+	}
+
+	// Only the spans failing with a transient error are written again.
+	pending := make([]int, len(documents))
+	for i := range pending {
+		pending[i] = i
+	}
 
-	span.TraceID = jSpan.TraceID.String()
-	span.SpanID = jSpan.SpanID.String()
-	span.OperationName = redis.Tokenization(jSpan.OperationName)
-	span.StartTime = jModel.TimeAsEpochMicroseconds(jSpan.StartTime)
-	span.Duration = jModel.DurationAsMicroseconds(jSpan.Duration)
-	span.References = model.ConvertReferencesFromJaeger(jSpan)
-	span.ProcessID = jSpan.ProcessID
-	span.Process = model.ConvertProcessFromJager(jSpan.Process)
-	span.Tags = model.ConvertKeyValuesFromJaeger(jSpan.Tags)
-	span.MultipleTags = model.MergeTags(jSpan)
-	span.Logs = model.ConvertLogFromJaeger(jSpan.Logs)
-	span.Warnings = jSpan.Warnings
+	err := s.resilience.Do(context, "write", func() error {
+		spans := make([]*model.Span, len(pending))
+		for j, i := range pending {
+			spans[j] = documents[i]
+		}
 
-	err := s.repository.Save(context, span)
+		batchErrs := make([]error, len(spans))
+		for _, j := range s.write(context, spans, batchErrs) {
+			stored = append(stored, pending[j])
+		}
 
-	if err != nil {
-		metrics.WritesLantency.WithLabelValues(spanIndexName, "Error").Observe(time.Since(writeStart).Seconds())
-		return err
+		var retriable error
+		failed := []int{}
+		for j, i := range pending {
+			errs[i] = batchErrs[j]
+			if IsRetriable(batchErrs[j]) {
+				failed = append(failed, i)
+				retriable = batchErrs[j]
+			}
+		}
+		pending = failed
+		return retriable
+	})
+
+	if err == ErrCircuitOpen {
+		for _, i := range pending {
+			errs[i] = err
+		}
 	}
 
-	setTTL(context, s.client, fmt.Sprintf("%v:%v", spanIndexName, span.Key), s.config.RedisTTL)
+	if s.config.SpanMerge {
+		for _, i := range stored {
+			errs[i] = s.resilience.Do(context, "merge", func() error {
+				return s.merge(context, jSpans[i], operations[i])
+			})
+		}
+	}
 
-	metrics.WritesLantency.WithLabelValues(spanIndexName, "Ok").Observe(time.Since(writeStart).Seconds())
-	metrics.WritesTotal.WithLabelValues(spanIndexName).Inc()
+	for i, jSpan := range jSpans {
+		if errs[i] == nil {
+			s.operations.Register(jSpan, operations[i])
+		}
+	}
 
-	return nil
+	s.observe(writeStart, errs)
+	return errs
+}
+
+func (s *SpanRepository) observe(writeStart time.Time, errs []error) {
+	for _, err := range errs {
+		s.durability.observe(writeStart, err)
+		if err != nil {
+			metrics.WritesLantency.WithLabelValues(spanIndexName, "Error").Observe(time.Since(writeStart).Seconds())
+			continue
+		}
+		metrics.WritesLantency.WithLabelValues(spanIndexName, "Ok").Observe(time.Since(writeStart).Seconds())
+		metrics.WritesTotal.WithLabelValues(spanIndexName).Inc()
+	}
+}
+
+// write stores the spans through the span write script when it was loaded, through pipelined commands otherwise.
+// It returns the index of the spans that were already stored.
+func (s *SpanRepository) write(context context.Context, spans []*model.Span, errs []error) []int {
+	if s.script != nil {
+		return s.writeWithScript(context, spans, errs)
+	}
+	return s.writeWithCommands(context, spans, errs)
+}
+
+// writeWithScript stores the spans through spanWriteScript and returns the index of the spans that were already stored.
+func (s *SpanRepository) writeWithScript(context context.Context, spans []*model.Span, errs []error) []int {
+	keys := make([][]string, len(spans))
+	args := make([][]string, len(spans))
+	cmds := make(rueidis.Commands, len(spans))
+
+	ttl := strconv.FormatInt(int64(s.config.RedisTTL.Seconds()), 10)
+	for i, span := range spans {
+		keys[i] = []string{spanKey(span.Key)}
+		args[i] = []string{rueidis.JSON(span), ttl}
+		cmds[i] = s.script.evalsha(s.client, keys[i], args[i])
+	}
+
+	resps, waitErr := s.durability.doMulti(context, s.client, cmds)
+
+	stored := []int{}
+	retries := []int{}
+	for i, resp := range resps {
+		written, err := resp.AsInt64()
+		errs[i] = err
+		if isNoScript(err) {
+			retries = append(retries, i)
+		} else if err == nil && written == 0 {
+			stored = append(stored, i)
+		}
+		if errs[i] == nil {
+			errs[i] = waitErr
+		}
+	}
+
+	// The script cache is lost when the server restarts or fails over, so send the script body again.
+	if len(retries) == 0 {
+		return stored
+	}
+
+	cmds = make(rueidis.Commands, len(retries))
+	for i, j := range retries {
+		cmds[i] = s.script.eval(s.client, keys[j], args[j])
+	}
+
+	resps, waitErr = s.durability.doMulti(context, s.client, cmds)
+	for i, resp := range resps {
+		written, err := resp.AsInt64()
+		errs[retries[i]] = err
+		if err == nil && written == 0 {
+			stored = append(stored, retries[i])
+		}
+		if errs[retries[i]] == nil {
+			errs[retries[i]] = waitErr
+		}
+	}
+	return stored
+}
+
+// writeWithCommands stores the spans through pipelined commands and returns the index of the spans that were already stored.
+func (s *SpanRepository) writeWithCommands(context context.Context, spans []*model.Span, errs []error) []int {
+	cmds := make(rueidis.Commands, 0, len(spans)*2)
+	for _, span := range spans {
+		key := spanKey(span.Key)
+		cmds = append(cmds,
+			s.client.B().JsonSet().Key(key).Path("$").Value(rueidis.JSON(span)).Nx().Build(),
+			expireCommand(s.client, key, s.config.RedisTTL))
+	}
+
+	resps, waitErr := s.durability.doMulti(context, s.client, cmds)
+
+	stored := []int{}
+	for i, resp := range resps {
+		err := resp.Error()
+		// JSON.SET NX replies nil when the span is already stored.
+		if rueidis.IsRedisNil(err) {
+			stored = append(stored, i/2)
+			continue
+		}
+		if err != nil && errs[i/2] == nil {
+			errs[i/2] = err
+		}
+	}
+
+	for i := range errs {
+		if errs[i] == nil {
+			errs[i] = waitErr
+		}
+	}
+	return stored
+}
+
+// merge folds the span into the copy that is already stored, retrying when the stored copy changes concurrently.
+func (s *SpanRepository) merge(context context.Context, jSpan *jModel.Span, operation string) error {
+	span := s.newSpan(jSpan, operation)
+
+	for attempt := 0; attempt < maxMergeAttempts; attempt++ {
+		stored, err := s.repository.Fetch(context, span.Key)
+		if om.IsRecordNotFound(err) {
+			stored, err = s.newSpan(jSpan, operation), nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.keyring != nil {
+			if err := s.keyring.Open(stored); err != nil {
+				return err
+			}
+		}
+
+		if err := model.ExpandSpan(stored); err != nil {
+			return err
+		}
+
+		stored.UnescapeNames()
+		model.MergeSpan(stored, span)
+
+		// The stored copy may have been written before interning, or merge process tags the written record does not have,
+		// so the record of the merged process is written unless it was already.
+		if s.config.ProcessInterning {
+			record := s.processes.intern(stored)
+			if due := s.processes.due(map[string]*model.ProcessRecord{stored.ProcessHash: record}); len(due) > 0 {
+				if err := s.processes.write(context, due); err != nil {
+					return err
+				}
+			}
+		}
+
+		if s.config.SpanStorage == StorageCompact {
+			merged, err := model.ConvertSpanToJaeger(stored)
+			if err != nil {
+				return err
+			}
+			if err := model.CompactSpan(stored, merged); err != nil {
+				return err
+			}
+		}
+
+		if s.keyring != nil {
+			if err := s.keyring.Seal(stored); err != nil {
+				return err
+			}
+		}
+
+		err = s.repository.Save(context, stored)
+		if err == om.ErrVersionMismatch {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		return s.client.Do(context, expireCommand(s.client, spanKey(span.Key), s.config.RedisTTL)).Error()
+	}
+
+	return om.ErrVersionMismatch
+}
+
+// newDocument converts the span to the document stored in Redis, compacting it in compact storage mode
+// and encrypting it when encryption at rest is enabled. With process interning, the record of the process
+// the document references is added to records.
+func (s *SpanRepository) newDocument(jSpan *jModel.Span, operation string, records map[string]*model.ProcessRecord) (*model.Span, error) {
+	span := s.newSpan(jSpan, operation)
+	if s.config.ProcessInterning {
+		record := s.processes.intern(span)
+		records[span.ProcessHash] = record
+	}
+
+	if s.config.SpanStorage == StorageCompact {
+		compacted := jSpan
+		if s.config.ProcessInterning {
+			withoutTags := *jSpan
+			withoutTags.Process = &jModel.Process{ServiceName: jSpan.Process.ServiceName}
+			compacted = &withoutTags
+		}
+
+		if err := model.CompactSpan(span, compacted); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.keyring == nil {
+		return span, nil
+	}
+	return span, s.keyring.Seal(span)
+}
+
+// newSpan converts the span to its document, operation being the name the operation is registered under in the catalog.
+func (s *SpanRepository) newSpan(jSpan *jModel.Span, operation string) *model.Span {
+	span := model.ConvertSpanFromJaeger(jSpan)
+	span.Key = spanDocumentID(jSpan.TraceID.String(), jSpan.SpanID.String(), jSpan.Process.ServiceName)
+	span.CatalogName = operation
+
+	// With process interning, process tags are searched through the record of the process, not copied into every span.
+	if s.config.ProcessInterning {
+		own := *jSpan
+		own.Process = &jModel.Process{ServiceName: jSpan.Process.ServiceName}
+		span.TagPairs = model.TagPairs(&own)
+	}
+
+	return span
+}
+
+// Ping checks Redis is reachable and answering.
+func (s *SpanRepository) Ping(context context.Context) error {
+	return s.client.Do(context, s.client.B().Ping().Build()).Error()
 }
 
 func (s *SpanRepository) GetTracesId(context context.Context, queryParameters model.TraceQueryParameters) ([]string, error) {
-	cursor, err := s.repository.Aggregate(context, func(search om.FtAggregateIndex) om.Completed {
-		query := buildQueryFilter(queryParameters)
-		return search.Query(query).LoadAll().Groupby(1).Property("@traceID").Reduce("COUNT").Nargs(0).Sortby(1).Property("@traceID").Max(queryParameters.NumTraces).Build()
+	var c []map[string]string
+	err := s.resilience.Do(context, "GetTracesId", func() error {
+		// Interned process tags are matched through the records holding them, by the hashes the spans reference them by.
+		processHashes := make(map[string][]string, len(queryParameters.Tags))
+		for key, value := range queryParameters.Tags {
+			query := fmt.Sprintf("@serviceName:{%s} @tagPair:{%s}", redis.EscapeTag(queryParameters.ServiceName), tagPairTerms(key, value, s.redactor, s.keyring))
+			hashes, err := s.processes.hashes(context, query)
+			if err != nil {
+				return err
+			}
+			processHashes[key] = hashes
+		}
+
+		cursor, err := s.repository.Aggregate(context, func(search om.FtAggregateIndex) om.Completed {
+			query := buildQueryFilter(queryParameters, s.redactor, s.keyring, processHashes)
+			return search.Query(query).LoadAll().Groupby(1).Property("@traceID").Reduce("COUNT").Nargs(0).Sortby(1).Property("@traceID").Max(queryParameters.NumTraces).Build()
+		})
+		if err != nil {
+			return err
+		}
+
+		c, err = cursor.Read(context)
+		return err
 	})
 
 	if err != nil {
@@ -120,12 +536,6 @@ func (s *SpanRepository) GetTracesId(context context.Context, queryParameters mo
 	}
 
 	traceIds := []string{}
-	c, err := cursor.Read(context)
-	if err != nil {
-		s.logger.Error(err.Error())
-		return nil, err
-	}
-
 	for _, s := range c {
 		traceIds = append(traceIds, s["traceID"])
 	}
@@ -134,9 +544,17 @@ func (s *SpanRepository) GetTracesId(context context.Context, queryParameters mo
 }
 
 func (s *SpanRepository) GetTracesById(context context.Context, ids []string) (map[string]*jModel.Trace, error) {
-	_, spans, err := s.repository.Search(context, func(search om.FtSearchIndex) om.Completed {
-		query := fmt.Sprintf("@traceID:(%s)", strings.Join(ids, "|"))
-		return search.Query(query).Limit().OffsetNum(0, s.config.MaxNumSpans).Build()
+	var spans []*model.Span
+	err := s.resilience.Do(context, "GetTracesById", func() (err error) {
+		_, spans, err = s.repository.Search(context, func(search om.FtSearchIndex) om.Completed {
+			escaped := make([]string, len(ids))
+			for i, id := range ids {
+				escaped[i] = redis.EscapeTag(id)
+			}
+			query := fmt.Sprintf("@traceID:{%s}", strings.Join(escaped, "|"))
+			return search.Query(query).Limit().OffsetNum(0, s.config.MaxNumSpans).Build()
+		})
+		return err
 	})
 
 	if err != nil {
@@ -144,43 +562,82 @@ func (s *SpanRepository) GetTracesById(context context.Context, ids []string) (m
 		return nil, err
 	}
 
-	tracesMap := make(map[string]*jModel.Trace, len(ids))
 	for _, span := range spans {
+		span.UnescapeNames()
+
+		if s.keyring != nil {
+			if err := s.keyring.Open(span); err != nil {
+				s.logger.Error("error to decrypt span", "key", span.Key, "err", err)
+				span.Warnings = append(span.Warnings, fmt.Sprintf("unable to decrypt span tags, logs and warnings: %v", err))
+				continue
+			}
+		}
+
+		if err := model.ExpandSpan(span); err != nil {
+			s.logger.Error("error to decode compact span", "key", span.Key, "err", err)
+			span.Warnings = append(span.Warnings, fmt.Sprintf("unable to decode span tags, logs, references and warnings: %v", err))
+		}
+	}
+
+	err = s.resilience.Do(context, "hydrate", func() error {
+		return s.processes.hydrate(context, spans)
+	})
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, err
+	}
+
+	// Spans written before document ids were derived from the span identity may be stored more than once.
+	unique := make(map[string]*model.Span, len(spans))
+	ordered := make([]*model.Span, 0, len(spans))
+	for _, span := range spans {
+		id := spanDocumentID(span.TraceID, span.SpanID, span.Process.ServiceName)
+		if stored, ok := unique[id]; ok {
+			model.MergeSpan(stored, span)
+			continue
+		}
+		unique[id] = span
+		ordered = append(ordered, span)
+	}
+
+	tracesMap := make(map[string]*jModel.Trace, len(ids))
+	for _, span := range ordered {
 		if _, ok := tracesMap[span.TraceID]; !ok {
 			tracesMap[span.TraceID] = &jModel.Trace{}
 		}
 
-		tId, _ := jModel.TraceIDFromString(span.TraceID)
-		sId, _ := jModel.SpanIDFromString(span.SpanID)
-		refs, _ := model.ConvertReferencesToJaeger(span.References)
-		tags, _ := model.ConvertKeyValuesToJaeger(span.Tags)
-		pTags, _ := model.ConvertKeyValuesToJaeger(span.Process.Tags)
-
-		s := jModel.Span{
-			TraceID:       tId,
-			SpanID:        sId,
-			References:    refs,
-			Tags:          tags,
-			StartTime:     jModel.EpochMicrosecondsAsTime(span.StartTime),
-			Duration:      jModel.MicrosecondsAsDuration(span.Duration),
-			Logs:          model.ConvertLogToJaeger(span.Logs),
-			OperationName: redis.UnTokenization(span.OperationName),
-			Process: &jModel.Process{
-				ServiceName: redis.UnTokenization(span.Process.ServiceName),
-				Tags:        pTags,
-			},
+		jSpan, err := model.ConvertSpanToJaeger(span)
+		if err != nil {
+			s.logger.Error("error to convert span", "key", span.Key, "err", err)
+			jSpan.Warnings = append(jSpan.Warnings, fmt.Sprintf("unable to read the span as stored: %v", err))
 		}
 
-		tracesMap[span.TraceID].Spans = append(tracesMap[span.TraceID].Spans, &s)
+		tracesMap[span.TraceID].Spans = append(tracesMap[span.TraceID].Spans, jSpan)
 	}
 	return tracesMap, nil
 }
 
-func buildQueryFilter(queryParameters model.TraceQueryParameters) string {
-	query := fmt.Sprintf("@processServiceName:%s", redis.Tokenization(queryParameters.ServiceName))
+func spanKey(id string) string {
+	return fmt.Sprintf("%v:%v", spanIndexName, id)
+}
 
+// spanDocumentID derives the document id of a span from its identity, so writing the same span twice targets the same document.
+// The service is part of the identity because Zipkin clients share the span id between the client and the server side of a call.
+func spanDocumentID(traceID string, spanID string, serviceName string) string {
+	h := fnv.New32a()
+	h.Write([]byte(serviceName))
+	return fmt.Sprintf("%s-%s-%x", traceID, spanID, h.Sum32())
+}
+
+// buildQueryFilter builds the search query of the parameters. Spans are matched by a tag when they hold it,
+// or reference one of processHashes, the records of the processes holding it, by tag key.
+func buildQueryFilter(queryParameters model.TraceQueryParameters, redactor *redaction.Redactor, keyring *encryption.Keyring, processHashes map[string][]string) string {
+	query := fmt.Sprintf("@processServiceName:{%s}", redis.EscapeTag(queryParameters.ServiceName))
+
+	// Operations are listed under their catalog name, which differs from the name of the spans when it is normalized.
 	if queryParameters.OperationName != "" {
-		query += fmt.Sprintf(" @operationName:%s", redis.Tokenization(queryParameters.OperationName))
+		operationName := redis.EscapeTag(queryParameters.OperationName)
+		query += fmt.Sprintf(" ((@operationName:{%s})|(@catalogName:{%s}))", operationName, operationName)
 	}
 
 	if queryParameters.DurationMax > 0 && queryParameters.DurationMin == 0 {
@@ -198,9 +655,16 @@ func buildQueryFilter(queryParameters model.TraceQueryParameters) string {
 	}
 
 	for key, value := range queryParameters.Tags {
-		query += fmt.Sprintf(" @mTagKey:{%s} @mTagValue:{%s}",
-			redis.Tokenization(key),
-			redis.Tokenization(value))
+		terms := tagPairTerms(key, value, redactor, keyring)
+		if hashes := processHashes[key]; len(hashes) > 0 {
+			escaped := make([]string, len(hashes))
+			for i, hash := range hashes {
+				escaped[i] = redis.EscapeTag(hash)
+			}
+			query += fmt.Sprintf(" ((@tagPair:{%s})|(@processHash:{%s}))", terms, strings.Join(escaped, "|"))
+			continue
+		}
+		query += fmt.Sprintf(" @tagPair:{%s}", terms)
 	}
 
 	query += fmt.Sprintf(" @startTime:[%v %v]",
@@ -209,3 +673,23 @@ func buildQueryFilter(queryParameters model.TraceQueryParameters) string {
 
 	return query
 }
+
+// tagPairTerms returns the terms of a TAG query matching the tag pair.
+// Values are redacted the way they are when stored, so spans holding a hashed value are found by its plain value.
+// With encryption, pairs are matched by their tokens under every key of the keyring.
+func tagPairTerms(key string, value string, redactor *redaction.Redactor, keyring *encryption.Keyring) string {
+	if redactor != nil {
+		value = redactor.Value(key, value)
+	}
+
+	pair := model.TagPair(key, value)
+	if keyring == nil {
+		return redis.EscapeTag(pair)
+	}
+
+	tokens := keyring.Tokens(pair)
+	for i, token := range tokens {
+		tokens[i] = redis.EscapeTag(token)
+	}
+	return strings.Join(tokens, "|")
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/golang/mock/gomock"
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+	"github.com/rueian/rueidis"
+	"github.com/rueian/rueidis/mock"
+	"github.com/rueian/rueidis/om"
+)
+
+func newTestSpanRepository(client rueidis.Client, config model.Configuration) *SpanRepository {
+	return &SpanRepository{
+		logger:     hclog.NewNullLogger(),
+		repository: om.NewJSONRepository(spanIndexName, model.Span{}, client),
+		resilience: NewResilience(config),
+		processes:  newProcessStore(client, nil, config.RedisTTL),
+		client:     client,
+		config:     config,
+	}
+}
+
+// searchReply encodes the documents as the reply of FT.SEARCH on a JSON index.
+func searchReply(t *testing.T, documents map[string]*model.Span) rueidis.RedisMessage {
+	values := []rueidis.RedisMessage{mock.RedisInt64(int64(len(documents)))}
+	for key, document := range documents {
+		data, err := json.Marshal(document)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		values = append(values, mock.RedisString(spanKey(key)), mock.RedisArray(mock.RedisString("$"), mock.RedisString(string(data))))
+	}
+	return mock.RedisArray(values...)
+}
+
+// TestGetTracesByIdMergesRepeatedSpan checks a span stored twice, under the random key of earlier versions
+// and under the key derived from its identity, is returned once with the data of both copies.
+func TestGetTracesByIdMergesRepeatedSpan(t *testing.T) {
+	ctx := context.Background()
+	client := mock.NewClient(gomock.NewController(t))
+	repository := newTestSpanRepository(client, model.Configuration{MaxNumSpans: 100})
+
+	traceID := jModel.NewTraceID(0, 1)
+	span := func(spanID uint64, tags ...jModel.KeyValue) *model.Span {
+		return model.ConvertSpanFromJaeger(&jModel.Span{
+			TraceID:       traceID,
+			SpanID:        jModel.NewSpanID(spanID),
+			OperationName: "GET /users",
+			Tags:          tags,
+			Process:       jModel.NewProcess("api", nil),
+		})
+	}
+
+	documents := map[string]*model.Span{
+		"01GFQ3XH2P0Q5D6ZJ8B1K4T7MW":                 span(2, jModel.String("http.method", "GET")),
+		spanDocumentID(traceID.String(), "2", "api"): span(2, jModel.String("http.method", "GET"), jModel.Int64("http.status_code", 200)),
+		spanDocumentID(traceID.String(), "3", "api"): span(3),
+	}
+	query := fmt.Sprintf("@traceID:{%s}", traceID.String())
+	client.EXPECT().Do(ctx, mock.Match("FT.SEARCH", "jsonidx:spans", query, "LIMIT", "0", "100")).Return(mock.Result(searchReply(t, documents)))
+
+	traces, err := repository.GetTracesById(ctx, []string{traceID.String()})
+	if err != nil {
+		t.Fatalf("GetTracesById() error = %v", err)
+	}
+
+	trace := traces[traceID.String()]
+	if trace == nil || len(trace.Spans) != 2 {
+		t.Fatalf("trace %+v, want spans 2 and 3 once each", trace)
+	}
+
+	for _, jSpan := range trace.Spans {
+		if jSpan.SpanID != jModel.NewSpanID(2) {
+			continue
+		}
+		if len(jSpan.Tags) != 2 {
+			t.Errorf("span 2 tags %v, want the tags of both copies once", jSpan.Tags)
+		}
+	}
+}
+
+func TestWriteChoosesScriptOrCommands(t *testing.T) {
+	span := &model.Span{Key: "1-2-3", TraceID: "1", SpanID: "2"}
+	document := rueidis.JSON(span)
+
+	tests := []struct {
+		name   string
+		script *script
+		expect func(client *mock.Client)
+	}{
+		{
+			name:   "script",
+			script: &script{body: spanWriteScript, sha: "5e1f"},
+			expect: func(client *mock.Client) {
+				client.EXPECT().DoMulti(gomock.Any(), mock.Match("EVALSHA", "5e1f", "1", "spans:1-2-3", document, "3600")).
+					Return([]rueidis.RedisResult{mock.Result(mock.RedisInt64(1))})
+			},
+		},
+		{
+			name: "commands",
+			expect: func(client *mock.Client) {
+				client.EXPECT().DoMulti(gomock.Any(), mock.Match("JSON.SET", "spans:1-2-3", "$", document, "NX"), mock.Match("EXPIRE", "spans:1-2-3", "3600")).
+					Return([]rueidis.RedisResult{mock.Result(mock.RedisString("OK")), mock.Result(mock.RedisInt64(1))})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := mock.NewClient(gomock.NewController(t))
+			tt.expect(client)
+
+			repository := newTestSpanRepository(client, model.Configuration{RedisTTL: time.Hour})
+			repository.durability = &durability{mode: DurabilityPrimaryAck}
+			repository.script = tt.script
+
+			errs := make([]error, 1)
+			if stored := repository.write(context.Background(), []*model.Span{span}, errs); len(stored) != 0 || errs[0] != nil {
+				t.Errorf("write() stored %v errors %v, want the span written", stored, errs)
+			}
+		})
+	}
+}
+
+// TestWriteWithScriptFallsBackToEval checks the spans are written through EVAL once the script cache of the server
+// was flushed, as after a restart or a failover.
+func TestWriteWithScriptFallsBackToEval(t *testing.T) {
+	ctx := context.Background()
+	client := mock.NewClient(gomock.NewController(t))
+
+	repository := newTestSpanRepository(client, model.Configuration{RedisTTL: time.Hour})
+	repository.durability = &durability{mode: DurabilityPrimaryAck}
+	repository.script = &script{body: spanWriteScript, sha: "5e1f"}
+
+	spans := []*model.Span{{Key: "1-2-3", TraceID: "1", SpanID: "2"}, {Key: "1-4-3", TraceID: "1", SpanID: "4"}}
+	gomock.InOrder(
+		client.EXPECT().DoMulti(ctx,
+			mock.Match("EVALSHA", "5e1f", "1", "spans:1-2-3", rueidis.JSON(spans[0]), "3600"),
+			mock.Match("EVALSHA", "5e1f", "1", "spans:1-4-3", rueidis.JSON(spans[1]), "3600")).
+			Return([]rueidis.RedisResult{
+				mock.Result(mock.RedisError("NOSCRIPT No matching script. Please use EVAL.")),
+				mock.Result(mock.RedisError("NOSCRIPT No matching script. Please use EVAL.")),
+			}),
+		client.EXPECT().DoMulti(ctx,
+			mock.Match("EVAL", spanWriteScript, "1", "spans:1-2-3", rueidis.JSON(spans[0]), "3600"),
+			mock.Match("EVAL", spanWriteScript, "1", "spans:1-4-3", rueidis.JSON(spans[1]), "3600")).
+			Return([]rueidis.RedisResult{mock.Result(mock.RedisInt64(1)), mock.Result(mock.RedisInt64(0))}),
+	)
+
+	errs := make([]error, len(spans))
+	stored := repository.writeWithScript(ctx, spans, errs)
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("span %d error = %v", i, err)
+		}
+	}
+	if want := []int{1}; !reflect.DeepEqual(stored, want) {
+		t.Errorf("already stored spans %v, want %v", stored, want)
+	}
+}
+
+func TestLoadWriteScript(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		// No command is expected: the script is not loaded.
+		client := mock.NewClient(gomock.NewController(t))
+		if loaded := loadWriteScript(hclog.NewNullLogger(), client, model.Configuration{}); loaded != nil {
+			t.Errorf("loadWriteScript() = %+v, want nil", loaded)
+		}
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		client := mock.NewClient(gomock.NewController(t))
+		client.EXPECT().Do(gomock.Any(), mock.Match("SCRIPT", "LOAD", spanWriteScript)).Return(mock.Result(mock.RedisError("NOPERM this user has no permissions to run the 'script' command")))
+
+		if loaded := loadWriteScript(hclog.NewNullLogger(), client, model.Configuration{RedisScripting: true}); loaded != nil {
+			t.Errorf("loadWriteScript() = %+v, want nil", loaded)
+		}
+	})
+
+	t.Run("loaded", func(t *testing.T) {
+		client := mock.NewClient(gomock.NewController(t))
+		client.EXPECT().Do(gomock.Any(), mock.Match("SCRIPT", "LOAD", spanWriteScript)).Return(mock.Result(mock.RedisString("5e1f")))
+
+		if loaded := loadWriteScript(hclog.NewNullLogger(), client, model.Configuration{RedisScripting: true}); loaded == nil || loaded.sha != "5e1f" {
+			t.Errorf("loadWriteScript() = %+v, want the script 5e1f", loaded)
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+	"github.com/rueian/rueidis"
+)
+
+const spanStreamField = "span"
+
+// ClaimStart is the cursor Claim starts from, and the one it returns once every pending entry was scanned.
+const ClaimStart = "0-0"
+
+var errEmptyStreamEntry = errors.New("stream entry does not hold a span")
+
+// ErrStreamFull is returned by Append when the stream holds as many entries as configured. Spans are refused
+// rather than trimmed before a consumer indexed them, until consumers catch up and Trim makes room.
+var ErrStreamFull = errors.New("ingestion stream is full")
+
+// StreamEntry is a span read from the ingestion stream.
+// Err is set when the entry could not be decoded, such entries will never be indexed.
+type StreamEntry struct {
+	ID   string
+	Span *jModel.Span
+	Err  error
+}
+
+// SpanStream is the durable ingestion tier: spans are appended to a Redis Stream
+// and read back by a consumer group that indexes them.
+type SpanStream struct {
+	logger hclog.Logger
+	client rueidis.Client
+	config model.Configuration
+}
+
+func NewSpanStream(logger hclog.Logger, redisClient rueidis.Client, config model.Configuration) (*SpanStream, error) {
+	err := redisClient.Do(context.TODO(), redisClient.B().XgroupCreate().Key(config.StreamName).Groupname(config.StreamGroup).Id("0").Mkstream().Build()).Error()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+
+	return &SpanStream{
+		logger: logger,
+		client: redisClient,
+		config: config,
+	}, nil
+}
+
+// Append adds the encoded spans to the stream using a single pipelined round trip, once the stream length is checked.
+// The returned slice holds the result of each span, in the same order as jSpans.
+func (s *SpanStream) Append(context context.Context, jSpans []*jModel.Span) []error {
+	errs := make([]error, len(jSpans))
+
+	length, err := s.client.Do(context, s.client.B().Xlen().Key(s.config.StreamName).Build()).AsInt64()
+	if err == nil && length+int64(len(jSpans)) > s.config.StreamMaxLen {
+		err = ErrStreamFull
+		metrics.StreamEntriesTotal.WithLabelValues("rejected").Add(float64(len(jSpans)))
+	}
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	cmds := make(rueidis.Commands, 0, len(jSpans))
+	owners := make([]int, 0, len(jSpans))
+	for i, jSpan := range jSpans {
+		data, err := jSpan.Marshal()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		cmds = append(cmds, s.client.B().Xadd().Key(s.config.StreamName).Id("*").FieldValue().FieldValue(spanStreamField, string(data)).Build())
+		owners = append(owners, i)
+	}
+
+	for i, resp := range s.client.DoMulti(context, cmds...) {
+		if errs[owners[i]] = resp.Error(); errs[owners[i]] == nil {
+			metrics.StreamEntriesTotal.WithLabelValues("appended").Inc()
+		}
+	}
+
+	return errs
+}
+
+// Read returns the next entries delivered to the consumer, waiting up to the configured block time for new ones.
+func (s *SpanStream) Read(context context.Context, consumer string) ([]StreamEntry, error) {
+	cmd := s.client.B().Xreadgroup().Group(s.config.StreamGroup, consumer).Count(s.config.StreamBatchSize).Block(s.config.StreamBlock.Milliseconds()).Streams().Key(s.config.StreamName).Id(">").Build()
+
+	streams, err := s.client.Do(context, cmd).AsXRead()
+	if rueidis.IsRedisNil(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeStreamEntries(streams[s.config.StreamName]), nil
+}
+
+// Claim takes over the entries delivered to other consumers that stayed unacknowledged for longer than the configured idle time,
+// so spans read by a crashed consumer are indexed anyway. It scans the pending entries from start and returns the cursor
+// the next call starts from, ClaimStart once every pending entry was scanned.
+func (s *SpanStream) Claim(context context.Context, consumer string, start string) ([]StreamEntry, string, error) {
+	minIdle := strconv.FormatInt(s.config.StreamClaimIdle.Milliseconds(), 10)
+	cmd := s.client.B().Xautoclaim().Key(s.config.StreamName).Group(s.config.StreamGroup).Consumer(consumer).MinIdleTime(minIdle).Start(start).Count(s.config.StreamBatchSize).Build()
+
+	resp, err := s.client.Do(context, cmd).ToArray()
+	if err != nil {
+		return nil, ClaimStart, err
+	}
+
+	if len(resp) < 2 {
+		return nil, ClaimStart, nil
+	}
+
+	next, err := resp[0].ToString()
+	if err != nil {
+		return nil, ClaimStart, err
+	}
+
+	entries, err := resp[1].AsXRange()
+	if err != nil {
+		return nil, ClaimStart, err
+	}
+
+	metrics.StreamEntriesTotal.WithLabelValues("reclaimed").Add(float64(len(entries)))
+	return decodeStreamEntries(entries), next, nil
+}
+
+// Ack acknowledges the processed entries, so they are not delivered again.
+func (s *SpanStream) Ack(context context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	cmd := s.client.B().Xack().Key(s.config.StreamName).Group(s.config.StreamGroup).Id(ids...).Build()
+	return s.client.Do(context, cmd).Error()
+}
+
+// Trim removes the entries the consumer group acknowledged: the ones delivered to the group before its oldest pending entry,
+// or before its last delivered entry when none is pending.
+func (s *SpanStream) Trim(context context.Context) error {
+	resps := s.client.DoMulti(context,
+		s.client.B().XinfoGroups().Key(s.config.StreamName).Build(),
+		s.client.B().Xpending().Key(s.config.StreamName).Group(s.config.StreamGroup).Build())
+
+	groups, err := resps[0].ToArray()
+	if err != nil {
+		return err
+	}
+
+	threshold := ""
+	for _, group := range groups {
+		info, err := group.AsStrMap()
+		if err != nil {
+			return err
+		}
+		if info["name"] == s.config.StreamGroup {
+			threshold = info["last-delivered-id"]
+		}
+	}
+
+	// The summary of XPENDING lists the number of pending entries, then the oldest and the newest of them.
+	summary, err := resps[1].ToArray()
+	if err != nil {
+		return err
+	}
+	if len(summary) > 1 {
+		if pending, _ := summary[0].AsInt64(); pending > 0 {
+			threshold, _ = summary[1].ToString()
+		}
+	}
+
+	if threshold == "" {
+		return nil
+	}
+
+	trimmed, err := s.client.Do(context, s.client.B().Xtrim().Key(s.config.StreamName).Minid().Almost().Threshold(threshold).Build()).AsInt64()
+	if err != nil {
+		return err
+	}
+
+	metrics.StreamEntriesTotal.WithLabelValues("trimmed").Add(float64(trimmed))
+	return nil
+}
+
+func decodeStreamEntries(entries []rueidis.XRangeEntry) []StreamEntry {
+	decoded := make([]StreamEntry, 0, len(entries))
+	for _, entry := range entries {
+		data, ok := entry.FieldValues[spanStreamField]
+		if !ok {
+			decoded = append(decoded, StreamEntry{ID: entry.ID, Err: errEmptyStreamEntry})
+			continue
+		}
+
+		span := &jModel.Span{}
+		err := span.Unmarshal([]byte(data))
+		decoded = append(decoded, StreamEntry{ID: entry.ID, Span: span, Err: err})
+	}
+	return decoded
+}
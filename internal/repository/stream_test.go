@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/golang/mock/gomock"
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+	"github.com/rueian/rueidis"
+	"github.com/rueian/rueidis/mock"
+)
+
+var streamConfig = model.Configuration{
+	StreamName:      "spans-stream",
+	StreamGroup:     "indexers",
+	StreamMaxLen:    10,
+	StreamBatchSize: 100,
+	StreamClaimIdle: 30 * time.Second,
+}
+
+func newTestSpanStream(t *testing.T) (*SpanStream, *mock.Client) {
+	client := mock.NewClient(gomock.NewController(t))
+	client.EXPECT().Do(gomock.Any(), mock.Match("XGROUP", "CREATE", "spans-stream", "indexers", "0", "MKSTREAM")).Return(mock.Result(mock.RedisString("OK")))
+
+	stream, err := NewSpanStream(hclog.NewNullLogger(), client, streamConfig)
+	if err != nil {
+		t.Fatalf("NewSpanStream() error = %v", err)
+	}
+	return stream, client
+}
+
+func streamEntry(id string, fieldValues ...string) rueidis.RedisMessage {
+	values := make([]rueidis.RedisMessage, 0, len(fieldValues))
+	for _, value := range fieldValues {
+		values = append(values, mock.RedisString(value))
+	}
+	return mock.RedisArray(mock.RedisString(id), mock.RedisArray(values...))
+}
+
+func TestAppendRefusesSpansAtCapacity(t *testing.T) {
+	stream, client := newTestSpanStream(t)
+	ctx := context.Background()
+
+	// No XADD is expected: the spans that would take the stream past its capacity are refused as a whole.
+	client.EXPECT().Do(ctx, mock.Match("XLEN", "spans-stream")).Return(mock.Result(mock.RedisInt64(9)))
+
+	spans := []*jModel.Span{{SpanID: 1}, {SpanID: 2}}
+	for i, err := range stream.Append(ctx, spans) {
+		if !errors.Is(err, ErrStreamFull) {
+			t.Errorf("span %d error = %v, want %v", i, err, ErrStreamFull)
+		}
+	}
+}
+
+func TestAppendAddsSpansBelowCapacity(t *testing.T) {
+	stream, client := newTestSpanStream(t)
+	ctx := context.Background()
+
+	client.EXPECT().Do(ctx, mock.Match("XLEN", "spans-stream")).Return(mock.Result(mock.RedisInt64(8)))
+	client.EXPECT().DoMulti(ctx, gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("1-0")),
+		mock.Result(mock.RedisString("1-1")),
+	})
+
+	for i, err := range stream.Append(ctx, []*jModel.Span{{SpanID: 1}, {SpanID: 2}}) {
+		if err != nil {
+			t.Errorf("span %d error = %v", i, err)
+		}
+	}
+}
+
+func TestTrimKeepsPendingEntries(t *testing.T) {
+	tests := []struct {
+		name      string
+		summary   rueidis.RedisMessage
+		threshold string
+	}{
+		{
+			name:      "oldest pending entry",
+			summary:   mock.RedisArray(mock.RedisInt64(2), mock.RedisString("5-0"), mock.RedisString("8-0"), mock.RedisArray()),
+			threshold: "5-0",
+		},
+		{
+			name:      "last delivered entry when none is pending",
+			summary:   mock.RedisArray(mock.RedisInt64(0), mock.RedisNil(), mock.RedisNil(), mock.RedisNil()),
+			threshold: "9-0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, client := newTestSpanStream(t)
+			ctx := context.Background()
+
+			groups := mock.RedisArray(
+				mock.RedisMap(map[string]rueidis.RedisMessage{"name": mock.RedisString("other"), "last-delivered-id": mock.RedisString("12-0")}),
+				mock.RedisMap(map[string]rueidis.RedisMessage{"name": mock.RedisString("indexers"), "last-delivered-id": mock.RedisString("9-0")}),
+			)
+			client.EXPECT().DoMulti(ctx, mock.Match("XINFO", "GROUPS", "spans-stream"), mock.Match("XPENDING", "spans-stream", "indexers")).
+				Return([]rueidis.RedisResult{mock.Result(groups), mock.Result(tt.summary)})
+			client.EXPECT().Do(ctx, mock.Match("XTRIM", "spans-stream", "MINID", "~", tt.threshold)).Return(mock.Result(mock.RedisInt64(4)))
+
+			if err := stream.Trim(ctx); err != nil {
+				t.Fatalf("Trim() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestClaimTakesOverStaleEntries(t *testing.T) {
+	stream, client := newTestSpanStream(t)
+	ctx := context.Background()
+
+	data, err := (&jModel.Span{SpanID: 7, OperationName: "GET /users"}).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	reply := mock.RedisArray(
+		mock.RedisString("6-0"),
+		mock.RedisArray(streamEntry("3-0", spanStreamField, string(data)), streamEntry("4-0", "other", "value")),
+		mock.RedisArray(),
+	)
+	client.EXPECT().Do(ctx, mock.Match("XAUTOCLAIM", "spans-stream", "indexers", "consumer-1", "30000", "2-0", "COUNT", "100")).Return(mock.Result(reply))
+
+	entries, next, err := stream.Claim(ctx, "consumer-1", "2-0")
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	if next != "6-0" {
+		t.Errorf("next cursor %q, want %q", next, "6-0")
+	}
+	if len(entries) != 2 {
+		t.Fatalf("claimed %d entries, want 2", len(entries))
+	}
+	if entries[0].ID != "3-0" || entries[0].Err != nil || entries[0].Span.OperationName != "GET /users" {
+		t.Errorf("first entry %+v, want the span of 3-0", entries[0])
+	}
+	if entries[1].ID != "4-0" || !errors.Is(entries[1].Err, errEmptyStreamEntry) {
+		t.Errorf("second entry %+v, want an undecodable entry", entries[1])
+	}
+}
+
+func TestClaimRestartsAfterAnError(t *testing.T) {
+	stream, client := newTestSpanStream(t)
+	ctx := context.Background()
+
+	client.EXPECT().Do(ctx, gomock.Any()).Return(mock.ErrorResult(errors.New("connection reset")))
+
+	if _, next, err := stream.Claim(ctx, "consumer-1", "2-0"); err == nil || next != ClaimStart {
+		t.Errorf("Claim() cursor %q error %v, want %q and an error", next, err, ClaimStart)
+	}
+}
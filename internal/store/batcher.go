@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+var errBatcherClosed = errors.New("span batcher is closed")
+
+type batchRequest struct {
+	span   *jModel.Span
+	result chan error
+}
+
+// spanBatcher accumulates spans and hands them over to flush
+// once size spans are pending or linger has passed since the first one.
+type spanBatcher struct {
+	logger   hclog.Logger
+	flush    func(ctx context.Context, spans []*jModel.Span) []error
+	size     int
+	linger   time.Duration
+	requests chan *batchRequest
+	mu       sync.RWMutex
+	closed   bool
+	done     chan struct{}
+}
+
+func newSpanBatcher(logger hclog.Logger, size int, linger time.Duration, flush func(ctx context.Context, spans []*jModel.Span) []error) *spanBatcher {
+	b := &spanBatcher{
+		logger:   logger,
+		flush:    flush,
+		size:     size,
+		linger:   linger,
+		requests: make(chan *batchRequest, size),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Write queues the span in the current batch and waits until the batch is flushed.
+func (b *spanBatcher) Write(ctx context.Context, span *jModel.Span) error {
+	request, err := b.Submit(ctx, span)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-request.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Submit queues the span in the current batch without waiting for the flush.
+// The flush result can be read from the returned request.
+func (b *spanBatcher) Submit(ctx context.Context, span *jModel.Span) (*batchRequest, error) {
+	request := &batchRequest{span: span, result: make(chan error, 1)}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return nil, errBatcherClosed
+	}
+
+	select {
+	case b.requests <- request:
+		return request, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close flushes every pending span and stops the batcher.
+func (b *spanBatcher) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.requests)
+	}
+	b.mu.Unlock()
+
+	<-b.done
+	return nil
+}
+
+func (b *spanBatcher) run() {
+	defer close(b.done)
+
+	batch := make([]*batchRequest, 0, b.size)
+	var linger <-chan time.Time
+
+	for {
+		select {
+		case request, ok := <-b.requests:
+			if !ok {
+				b.flushBatch(batch, "shutdown")
+				return
+			}
+
+			batch = append(batch, request)
+			if len(batch) == 1 {
+				linger = time.After(b.linger)
+			}
+
+			if len(batch) >= b.size {
+				b.flushBatch(batch, "size")
+				batch = make([]*batchRequest, 0, b.size)
+				linger = nil
+			}
+		case <-linger:
+			b.flushBatch(batch, "linger")
+			batch = make([]*batchRequest, 0, b.size)
+			linger = nil
+		}
+	}
+}
+
+func (b *spanBatcher) flushBatch(batch []*batchRequest, reason string) {
+	if len(batch) == 0 {
+		return
+	}
+
+	start := time.Now()
+
+	spans := make([]*jModel.Span, len(batch))
+	for i, request := range batch {
+		spans[i] = request.span
+	}
+
+	errs := b.flush(context.Background(), spans)
+
+	status := "Ok"
+	for i, request := range batch {
+		if errs[i] != nil {
+			status = "Error"
+		}
+		request.result <- errs[i]
+	}
+
+	metrics.BatchSize.Observe(float64(len(batch)))
+	metrics.BatchFlushTotal.WithLabelValues(reason).Inc()
+	metrics.BatchFlushLatency.WithLabelValues(status).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+// flushes records the size of each flushed batch, failing the spans of the failed trace.
+type flushes struct {
+	mu     sync.Mutex
+	sizes  []int
+	failed uint64
+}
+
+func (f *flushes) flush(ctx context.Context, spans []*jModel.Span) []error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sizes = append(f.sizes, len(spans))
+	errs := make([]error, len(spans))
+	for i, span := range spans {
+		if span.TraceID.Low == f.failed {
+			errs[i] = errors.New("rejected")
+		}
+	}
+	return errs
+}
+
+func (f *flushes) all() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int{}, f.sizes...)
+}
+
+func TestSpanBatcherFlushes(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		linger time.Duration
+		spans  int
+		want   []int
+	}{
+		{name: "by size", size: 2, linger: time.Hour, spans: 4, want: []int{2, 2}},
+		{name: "by linger", size: 10, linger: 10 * time.Millisecond, spans: 3, want: []int{3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &flushes{}
+			batcher := newSpanBatcher(hclog.NewNullLogger(), test.size, test.linger, f.flush)
+			defer batcher.Close()
+
+			var wg sync.WaitGroup
+			for traceID := 1; traceID <= test.spans; traceID++ {
+				request, err := batcher.Submit(context.Background(), testSpan(uint64(traceID), "api"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-request.result
+				}()
+			}
+			wg.Wait()
+
+			sizes := f.all()
+			if len(sizes) != len(test.want) {
+				t.Fatalf("flushed batches of %v, want %v", sizes, test.want)
+			}
+			for i := range sizes {
+				if sizes[i] != test.want[i] {
+					t.Fatalf("flushed batches of %v, want %v", sizes, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSpanBatcherReturnsTheResultOfEachSpan(t *testing.T) {
+	f := &flushes{failed: 2}
+	batcher := newSpanBatcher(hclog.NewNullLogger(), 3, time.Hour, f.flush)
+	defer batcher.Close()
+
+	errs := make([]error, 3)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = batcher.Write(context.Background(), testSpan(uint64(i+1), "api"))
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if (err != nil) != (i == 1) {
+			t.Errorf("span %d returned %v", i+1, err)
+		}
+	}
+}
+
+func TestSpanBatcherFlushesOnClose(t *testing.T) {
+	f := &flushes{}
+	batcher := newSpanBatcher(hclog.NewNullLogger(), 10, time.Hour, f.flush)
+
+	for traceID := uint64(1); traceID <= 3; traceID++ {
+		if _, err := batcher.Submit(context.Background(), testSpan(traceID, "api")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batcher.Close()
+
+	if sizes := f.all(); len(sizes) != 1 || sizes[0] != 3 {
+		t.Errorf("flushed batches of %v on close, want [3]", sizes)
+	}
+	if _, err := batcher.Submit(context.Background(), testSpan(4, "api")); err != errBatcherClosed {
+		t.Errorf("expected the closed batcher to reject spans, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/repository"
+
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+// trimInterval is how often a consumer trims the acknowledged entries from the stream, making room for new ones.
+const trimInterval = time.Second
+
+// Indexer consumes the spans appended to the ingestion stream by SpanWriter and stores them in the spans index.
+// Entries are only acknowledged once stored, so spans read by a consumer that crashes are claimed and indexed by another one.
+type Indexer struct {
+	logger         hclog.Logger
+	stream         *repository.SpanStream
+	spanRepository *repository.SpanRepository
+	consumers      int
+	claimInterval  time.Duration
+	stop           chan struct{}
+	wg             sync.WaitGroup
+}
+
+func NewIndexer(logger hclog.Logger, stream *repository.SpanStream, spanRepository *repository.SpanRepository, consumers int, config model.Configuration) *Indexer {
+	return &Indexer{
+		logger:         logger,
+		stream:         stream,
+		spanRepository: spanRepository,
+		consumers:      consumers,
+		claimInterval:  config.StreamClaimIdle,
+		stop:           make(chan struct{}),
+	}
+}
+
+// Start launches the consumers of the indexer.
+func (i *Indexer) Start() {
+	hostname, _ := os.Hostname()
+
+	i.wg.Add(i.consumers)
+	for n := 0; n < i.consumers; n++ {
+		go i.consume(fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), n))
+	}
+}
+
+// Close stops the consumers once their current entries are processed.
+func (i *Indexer) Close() error {
+	close(i.stop)
+	i.wg.Wait()
+	return nil
+}
+
+func (i *Indexer) consume(consumer string) {
+	defer i.wg.Done()
+
+	lastClaim := time.Time{}
+	lastTrim := time.Time{}
+	cursor := repository.ClaimStart
+	for {
+		select {
+		case <-i.stop:
+			return
+		default:
+		}
+
+		ctx := context.Background()
+
+		// Claiming goes on from the cursor until every pending entry was scanned, then waits for the claim interval.
+		if cursor != repository.ClaimStart || time.Since(lastClaim) >= i.claimInterval {
+			if cursor == repository.ClaimStart {
+				lastClaim = time.Now()
+			}
+
+			var entries []repository.StreamEntry
+			var err error
+			entries, cursor, err = i.stream.Claim(ctx, consumer, cursor)
+			if err != nil {
+				i.logger.Error("error to claim pending spans", "consumer", consumer, "err", err)
+			}
+			i.index(ctx, entries, i.spanRepository.RewriteBatch)
+		}
+
+		if time.Since(lastTrim) >= trimInterval {
+			lastTrim = time.Now()
+			if err := i.stream.Trim(ctx); err != nil {
+				i.logger.Error("error to trim acknowledged spans from stream", "consumer", consumer, "err", err)
+			}
+		}
+
+		entries, err := i.stream.Read(ctx, consumer)
+		if err != nil {
+			i.logger.Error("error to read spans from stream", "consumer", consumer, "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		i.index(ctx, entries, i.spanRepository.WriteBatch)
+	}
+}
+
+// index writes the spans of the entries with write, and acknowledges the entries written.
+// Claimed entries were delivered before, so they are written without counting them against the trace limit again.
+func (i *Indexer) index(ctx context.Context, entries []repository.StreamEntry, write func(ctx context.Context, spans []*jModel.Span) []error) {
+	if len(entries) == 0 {
+		return
+	}
+
+	acks := make([]string, 0, len(entries))
+	ids := make([]string, 0, len(entries))
+	spans := make([]*jModel.Span, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Err != nil {
+			// An entry that cannot be decoded will never be indexed, keeping it pending would only redeliver it forever.
+			i.logger.Error("error to decode span from stream", "id", entry.ID, "err", entry.Err)
+			metrics.StreamEntriesTotal.WithLabelValues("discarded").Inc()
+			acks = append(acks, entry.ID)
+			continue
+		}
+		ids = append(ids, entry.ID)
+		spans = append(spans, entry.Span)
+	}
+
+	for n, err := range write(ctx, spans) {
+		if err != nil {
+			i.logger.Error("error to index span from stream", "id", ids[n], "err", err)
+			metrics.StreamEntriesTotal.WithLabelValues("failed").Inc()
+			continue
+		}
+		metrics.StreamEntriesTotal.WithLabelValues("indexed").Inc()
+		acks = append(acks, ids[n])
+	}
+
+	if err := i.stream.Ack(ctx, acks); err != nil {
+		i.logger.Error("error to acknowledge stream entries", "err", err)
+	}
+}
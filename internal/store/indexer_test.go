@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/repository"
+
+	"github.com/golang/mock/gomock"
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+	"github.com/rueian/rueidis/mock"
+)
+
+func TestIndexerAcksWrittenAndUndecodableEntries(t *testing.T) {
+	ctx := context.Background()
+	client := mock.NewClient(gomock.NewController(t))
+	config := model.Configuration{StreamName: "spans-stream", StreamGroup: "indexers"}
+
+	client.EXPECT().Do(gomock.Any(), mock.Match("XGROUP", "CREATE", "spans-stream", "indexers", "0", "MKSTREAM")).Return(mock.Result(mock.RedisString("OK")))
+	stream, err := repository.NewSpanStream(hclog.NewNullLogger(), client, config)
+	if err != nil {
+		t.Fatalf("NewSpanStream() error = %v", err)
+	}
+
+	// The entry that failed to be written stays pending to be claimed again, the undecodable one is acknowledged.
+	client.EXPECT().Do(ctx, mock.Match("XACK", "spans-stream", "indexers", "2-0", "1-0", "4-0")).Return(mock.Result(mock.RedisInt64(3)))
+
+	entries := []repository.StreamEntry{
+		{ID: "1-0", Span: &jModel.Span{SpanID: 1}},
+		{ID: "2-0", Err: errors.New("unexpected EOF")},
+		{ID: "3-0", Span: &jModel.Span{SpanID: 3}},
+		{ID: "4-0", Span: &jModel.Span{SpanID: 4}},
+	}
+
+	var written []jModel.SpanID
+	write := func(ctx context.Context, spans []*jModel.Span) []error {
+		errs := make([]error, len(spans))
+		for i, span := range spans {
+			written = append(written, span.SpanID)
+			if span.SpanID == 3 {
+				errs[i] = errors.New("connection reset")
+			}
+		}
+		return errs
+	}
+
+	indexer := NewIndexer(hclog.NewNullLogger(), stream, nil, 1, config)
+	indexer.index(ctx, entries, write)
+
+	if want := []jModel.SpanID{1, 3, 4}; !reflect.DeepEqual(written, want) {
+		t.Errorf("written spans %v, want %v", written, want)
+	}
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	priorityRegular = "regular"
+	priorityRoot    = "root"
+	priorityError   = "error"
+
+	// limiterSweepInterval is how often the buckets of the services that stopped sending spans are dropped.
+	limiterSweepInterval = time.Minute
+	// maxShedServiceLabels bounds the services the shed spans are counted under, the others being counted under shedOtherService.
+	maxShedServiceLabels = 100
+	shedOtherService     = "other"
+)
+
+// rateLimiter sheds the spans exceeding the per service and global ingestion rates, using token buckets.
+// Regular spans stop being admitted once a bucket falls to its reserve, which is left to root spans and then error spans,
+// so they are kept longest when a service is throttled.
+type rateLimiter struct {
+	serviceRate  float64
+	serviceBurst float64
+	overrides    map[string]float64
+	reserve      float64
+	global       *tokenBucket
+	mu           sync.Mutex
+	services     map[string]*tokenBucket
+	lastSweep    time.Time
+	shedLabels   map[string]struct{}
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(config model.Configuration) (*rateLimiter, error) {
+	if config.RateLimitReserve < 0 || config.RateLimitReserve >= 1 {
+		return nil, fmt.Errorf("invalid rate limit priority reserve: %v", config.RateLimitReserve)
+	}
+
+	l := &rateLimiter{
+		serviceRate:  config.RateLimitService,
+		serviceBurst: config.RateLimitServiceBurst,
+		overrides:    config.RateLimitServices,
+		reserve:      config.RateLimitReserve,
+		services:     map[string]*tokenBucket{},
+		lastSweep:    time.Now(),
+		shedLabels:   map[string]struct{}{},
+	}
+
+	if config.RateLimitGlobal > 0 {
+		l.global = newTokenBucket(config.RateLimitGlobal, config.RateLimitGlobalBurst, time.Now())
+	}
+
+	return l, nil
+}
+
+// newTokenBucket creates a full bucket, burst defaulting to one second worth of tokens and rounded up to whole tokens.
+func newTokenBucket(rate float64, burst float64, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = rate
+	}
+	burst = math.Max(math.Ceil(burst), 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// Allow reports whether the span is admitted, counting it as shed otherwise.
+func (l *rateLimiter) Allow(span *jModel.Span) bool {
+	service := ""
+	if span.Process != nil {
+		service = span.Process.ServiceName
+	}
+	priority := spanPriority(span)
+
+	l.mu.Lock()
+	now := time.Now()
+	l.sweep(now)
+
+	bucket := l.serviceBucket(service, now)
+	allowed := (bucket == nil || bucket.available(now, l.floor(bucket, priority))) &&
+		(l.global == nil || l.global.available(now, l.floor(l.global, priority)))
+
+	label := service
+	if allowed {
+		if bucket != nil {
+			bucket.tokens--
+		}
+		if l.global != nil {
+			l.global.tokens--
+		}
+	} else {
+		label = l.shedLabel(service)
+	}
+	l.mu.Unlock()
+
+	if !allowed {
+		metrics.ShedSpans.WithLabelValues(label, priority).Inc()
+	}
+	return allowed
+}
+
+// serviceBucket returns the bucket of the service, nil when the service is not limited. It must be called with the lock held.
+func (l *rateLimiter) serviceBucket(service string, now time.Time) *tokenBucket {
+	if bucket, ok := l.services[service]; ok {
+		return bucket
+	}
+
+	rate, ok := l.overrides[service]
+	if !ok {
+		rate = l.serviceRate
+	}
+	if rate <= 0 {
+		return nil
+	}
+
+	bucket := newTokenBucket(rate, l.serviceBurst, now)
+	l.services[service] = bucket
+	return bucket
+}
+
+// sweep drops the buckets that had time to refill since they were last used, as they are no different from new ones.
+// It must be called with the lock held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < limiterSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for service, bucket := range l.services {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate >= bucket.burst {
+			delete(l.services, service)
+		}
+	}
+}
+
+// shedLabel returns the service label the shed spans of the service are counted under. It must be called with the lock held.
+func (l *rateLimiter) shedLabel(service string) string {
+	if _, ok := l.shedLabels[service]; ok {
+		return service
+	}
+	if len(l.shedLabels) >= maxShedServiceLabels {
+		return shedOtherService
+	}
+	l.shedLabels[service] = struct{}{}
+	return service
+}
+
+// floor is the number of tokens a span of the priority must leave in the bucket.
+// Regular spans leave the whole reserve, root spans half of it and error spans none.
+// The floor stays a token below the burst, so a full bucket admits spans of any priority however small the rate.
+func (l *rateLimiter) floor(bucket *tokenBucket, priority string) float64 {
+	var floor float64
+	switch priority {
+	case priorityError:
+		return 0
+	case priorityRoot:
+		floor = bucket.burst * l.reserve / 2
+	default:
+		floor = bucket.burst * l.reserve
+	}
+	return math.Min(floor, bucket.burst-1)
+}
+
+// available refills the bucket and reports whether a token can be taken while leaving floor tokens.
+func (b *tokenBucket) available(now time.Time, floor float64) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	return b.tokens-1 >= floor
+}
+
+func spanPriority(span *jModel.Span) string {
+	if isError(span) {
+		return priorityError
+	}
+	if span.ParentSpanID() == 0 {
+		return priorityRoot
+	}
+	return priorityRegular
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+// childSpan returns a regular span, neither a root span nor an error span.
+func childSpan(traceID uint64, service string) *jModel.Span {
+	span := testSpan(traceID, service)
+	span.References = []jModel.SpanRef{jModel.NewChildOfRef(span.TraceID, jModel.NewSpanID(traceID+1000))}
+	return span
+}
+
+func errorSpan(traceID uint64, service string) *jModel.Span {
+	span := childSpan(traceID, service)
+	span.Tags = []jModel.KeyValue{jModel.Bool("error", true)}
+	return span
+}
+
+func TestRateLimiterAdmitsWithSmallRates(t *testing.T) {
+	tests := []struct {
+		name   string
+		config model.Configuration
+		spans  []*jModel.Span
+		want   []bool
+	}{
+		{
+			name:   "rate of 1 admits a regular span",
+			config: model.Configuration{RateLimitService: 1, RateLimitReserve: 0.2},
+			spans:  []*jModel.Span{childSpan(1, "api"), childSpan(2, "api")},
+			want:   []bool{true, false},
+		},
+		{
+			name:   "fractional rate admits a regular span",
+			config: model.Configuration{RateLimitService: 0.5, RateLimitReserve: 0.5},
+			spans:  []*jModel.Span{childSpan(1, "api"), childSpan(2, "api")},
+			want:   []bool{true, false},
+		},
+		{
+			name:   "global rate of 1 admits a regular span",
+			config: model.Configuration{RateLimitGlobal: 1, RateLimitReserve: 0.9},
+			spans:  []*jModel.Span{childSpan(1, "api"), childSpan(2, "web")},
+			want:   []bool{true, false},
+		},
+		{
+			name:   "reserve is left to root and error spans",
+			config: model.Configuration{RateLimitService: 10, RateLimitReserve: 0.2},
+			spans: append(
+				spansOf(8, func(i int) *jModel.Span { return childSpan(uint64(i), "api") }),
+				childSpan(9, "api"), testSpan(10, "api"), errorSpan(11, "api"), errorSpan(12, "api"), errorSpan(13, "api"),
+			),
+			want: []bool{true, true, true, true, true, true, true, true, false, true, true, false, false},
+		},
+		{
+			name:   "services have their own bucket",
+			config: model.Configuration{RateLimitService: 1, RateLimitServices: map[string]float64{"batch": 0}},
+			spans:  []*jModel.Span{childSpan(1, "api"), childSpan(2, "web"), childSpan(3, "batch"), childSpan(4, "batch"), childSpan(5, "api")},
+			want:   []bool{true, true, true, true, false},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter, err := newRateLimiter(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, span := range test.spans {
+				if got := limiter.Allow(span); got != test.want[i] {
+					t.Errorf("span %d admitted %v, want %v", i, got, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func spansOf(n int, span func(i int) *jModel.Span) []*jModel.Span {
+	spans := make([]*jModel.Span, n)
+	for i := range spans {
+		spans[i] = span(i)
+	}
+	return spans
+}
+
+func TestNewRateLimiterRejectsInvalidReserve(t *testing.T) {
+	for _, reserve := range []float64{-0.1, 1, 2} {
+		if _, err := newRateLimiter(model.Configuration{RateLimitReserve: reserve}); err == nil {
+			t.Errorf("reserve %v: expected an error", reserve)
+		}
+	}
+}
+
+// TestRateLimiterBoundsItsState checks the buckets of idle services are dropped and the shed spans
+// are counted under a bounded number of services.
+func TestRateLimiterBoundsItsState(t *testing.T) {
+	limiter, err := newRateLimiter(model.Configuration{RateLimitService: 1000, RateLimitServices: map[string]float64{"unlimited": 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		limiter.Allow(childSpan(uint64(i), fmt.Sprintf("service-%d", i)))
+	}
+	limiter.Allow(childSpan(100, "unlimited"))
+	if len(limiter.services) != 10 {
+		t.Fatalf("expected a bucket per service, got %d", len(limiter.services))
+	}
+
+	limiter.lastSweep = time.Now().Add(-2 * limiterSweepInterval)
+	for _, bucket := range limiter.services {
+		bucket.last = bucket.last.Add(-time.Minute)
+	}
+	limiter.Allow(childSpan(11, "service-11"))
+	if len(limiter.services) != 1 {
+		t.Errorf("expected the buckets of idle services to be dropped, %d left", len(limiter.services))
+	}
+
+	for i := 0; i < 2*maxShedServiceLabels; i++ {
+		limiter.shedLabel(fmt.Sprintf("service-%d", i))
+	}
+	if len(limiter.shedLabels) != maxShedServiceLabels {
+		t.Errorf("expected %d service labels, got %d", maxShedServiceLabels, len(limiter.shedLabels))
+	}
+	if label := limiter.shedLabel("service-1"); label != "service-1" {
+		t.Errorf("labelled service counted under %s", label)
+	}
+	if label := limiter.shedLabel("another"); label != shedOtherService {
+		t.Errorf("service beyond the limit counted under %s", label)
+	}
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	// ProcessorRename renames the key tag to the to tag.
+	ProcessorRename = "rename"
+	// ProcessorDrop removes the key tag.
+	ProcessorDrop = "drop"
+	// ProcessorSet sets the key tag to value.
+	ProcessorSet = "set"
+	// ProcessorCopyFromProcess copies the key process tag to the to span tag.
+	ProcessorCopyFromProcess = "copy-from-process"
+	// ProcessorExtract sets the to tag to the replacement expanded from the pattern matching the key tag.
+	ProcessorExtract = "extract"
+
+	// TargetSpan applies a processor to the span tags.
+	TargetSpan = "span"
+	// TargetProcess applies a processor to the process tags.
+	TargetProcess = "process"
+	// TargetLog applies a processor to the log fields.
+	TargetLog = "log"
+)
+
+// attributeProcessor rewrites the tags of the spans through a chain of steps, in the configured order.
+// Steps never fail the write, a step that cannot be applied records why in the span warnings.
+type attributeProcessor struct {
+	steps []processorStep
+}
+
+type processorStep struct {
+	model.AttributeProcessor
+	pattern *regexp.Regexp
+	span    bool
+	process bool
+	log     bool
+}
+
+func newAttributeProcessor(processors []model.AttributeProcessor) (*attributeProcessor, error) {
+	p := &attributeProcessor{steps: make([]processorStep, 0, len(processors))}
+
+	for i, processor := range processors {
+		if processor.Name == "" {
+			processor.Name = fmt.Sprintf("%s-%d", processor.Action, i)
+		}
+
+		if processor.Key == "" {
+			return nil, fmt.Errorf("attribute processor %s has no key", processor.Name)
+		}
+
+		step := processorStep{AttributeProcessor: processor}
+		switch processor.Action {
+		case ProcessorDrop, ProcessorSet:
+		case ProcessorRename, ProcessorCopyFromProcess:
+			if processor.To == "" {
+				return nil, fmt.Errorf("attribute processor %s has no target key", processor.Name)
+			}
+		case ProcessorExtract:
+			if processor.To == "" {
+				return nil, fmt.Errorf("attribute processor %s has no target key", processor.Name)
+			}
+
+			pattern, err := regexp.Compile(processor.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern of attribute processor %s: %w", processor.Name, err)
+			}
+			step.pattern = pattern
+
+			if step.Replacement == "" {
+				step.Replacement = "$1"
+			}
+		default:
+			return nil, fmt.Errorf("invalid action of attribute processor %s: %s", processor.Name, processor.Action)
+		}
+
+		// Steps rewrite the span tags unless wider targets are asked for: the process is shared by the spans of a batch,
+		// and log fields often reuse tag keys with another meaning.
+		targets := processor.Targets
+		if len(targets) == 0 {
+			targets = []string{TargetSpan}
+		}
+
+		for _, target := range targets {
+			switch target {
+			case TargetSpan:
+				step.span = true
+			case TargetProcess:
+				step.process = true
+			case TargetLog:
+				step.log = true
+			default:
+				return nil, fmt.Errorf("invalid target of attribute processor %s: %s", processor.Name, target)
+			}
+		}
+
+		// Process tags are copied to span tags, the targets do not apply.
+		if processor.Action == ProcessorCopyFromProcess {
+			step.span, step.process, step.log = true, false, false
+		}
+
+		p.steps = append(p.steps, step)
+	}
+
+	return p, nil
+}
+
+// Process applies the steps to the span.
+// The process and the logs may be shared with other spans, so they are copied rather than changed in place.
+func (p *attributeProcessor) Process(span *jModel.Span) {
+	for _, step := range p.steps {
+		if step.span {
+			span.Tags = step.apply(span, span.Tags)
+		}
+
+		if step.process && span.Process != nil {
+			process := *span.Process
+			process.Tags = step.apply(span, process.Tags)
+			span.Process = &process
+		}
+
+		if step.log && len(span.Logs) > 0 {
+			logs := make([]jModel.Log, len(span.Logs))
+			for i, log := range span.Logs {
+				logs[i] = jModel.Log{Timestamp: log.Timestamp, Fields: step.apply(span, log.Fields)}
+			}
+			span.Logs = logs
+		}
+	}
+}
+
+// apply returns kvs with the step applied, kvs itself is never changed in place.
+func (s processorStep) apply(span *jModel.Span, kvs []jModel.KeyValue) []jModel.KeyValue {
+	switch s.Action {
+	case ProcessorRename:
+		i := indexOf(kvs, s.Key)
+		if i < 0 {
+			return kvs
+		}
+
+		if indexOf(kvs, s.To) >= 0 {
+			addWarning(span, fmt.Sprintf("attribute processor %s: %s not renamed, %s already exists", s.Name, s.Key, s.To))
+			return kvs
+		}
+
+		result := append([]jModel.KeyValue{}, kvs...)
+		result[i].Key = s.To
+		return result
+	case ProcessorDrop:
+		result := make([]jModel.KeyValue, 0, len(kvs))
+		for _, kv := range kvs {
+			if kv.Key != s.Key {
+				result = append(result, kv)
+			}
+		}
+		return result
+	case ProcessorSet:
+		return setKeyValue(kvs, jModel.String(s.Key, s.Value))
+	case ProcessorCopyFromProcess:
+		if span.Process == nil {
+			return kvs
+		}
+
+		i := indexOf(span.Process.Tags, s.Key)
+		if i < 0 {
+			return kvs
+		}
+
+		kv := span.Process.Tags[i]
+		kv.Key = s.To
+		return setKeyValue(kvs, kv)
+	case ProcessorExtract:
+		i := indexOf(kvs, s.Key)
+		if i < 0 {
+			return kvs
+		}
+
+		value := kvs[i].AsString()
+		match := s.pattern.FindStringSubmatchIndex(value)
+		if match == nil {
+			addWarning(span, fmt.Sprintf("attribute processor %s: %s does not match %s", s.Name, s.Key, s.Pattern))
+			return kvs
+		}
+
+		extracted := s.pattern.ExpandString(nil, s.Replacement, value, match)
+		return setKeyValue(kvs, jModel.String(s.To, string(extracted)))
+	}
+	return kvs
+}
+
+// setKeyValue returns a copy of kvs where kv replaces the value with the same key, or is appended.
+func setKeyValue(kvs []jModel.KeyValue, kv jModel.KeyValue) []jModel.KeyValue {
+	result := append(make([]jModel.KeyValue, 0, len(kvs)+1), kvs...)
+	if i := indexOf(result, kv.Key); i >= 0 {
+		result[i] = kv
+		return result
+	}
+	return append(result, kv)
+}
+
+func indexOf(kvs []jModel.KeyValue, key string) int {
+	for i := range kvs {
+		if kvs[i].Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// addWarning records the warning once, a step applied to every log of a span may fail the same way for each of them.
+func addWarning(span *jModel.Span, warning string) {
+	for _, w := range span.Warnings {
+		if w == warning {
+			return
+		}
+	}
+	span.Warnings = append(span.Warnings, warning)
+}
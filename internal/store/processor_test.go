@@ -0,0 +1,147 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+// processedSpan returns a span with a tag, a process tag and a log field under each key.
+func processedSpan(keys ...string) *jModel.Span {
+	span := testSpan(1, "api")
+	span.Process = jModel.NewProcess("api", nil)
+	span.Logs = []jModel.Log{{}}
+	for _, key := range keys {
+		span.Tags = append(span.Tags, jModel.String(key, "span"))
+		span.Process.Tags = append(span.Process.Tags, jModel.String(key, "process"))
+		span.Logs[0].Fields = append(span.Logs[0].Fields, jModel.String(key, "log"))
+	}
+	return span
+}
+
+func TestAttributeProcessor(t *testing.T) {
+	tests := []struct {
+		name      string
+		processor model.AttributeProcessor
+		span      *jModel.Span
+		tags      []jModel.KeyValue
+		process   []jModel.KeyValue
+		fields    []jModel.KeyValue
+		warnings  int
+	}{
+		{
+			name:      "rename",
+			processor: model.AttributeProcessor{Action: ProcessorRename, Key: "http.url", To: "http.target"},
+			span:      processedSpan("http.url"),
+			tags:      []jModel.KeyValue{jModel.String("http.target", "span")},
+			process:   []jModel.KeyValue{jModel.String("http.url", "process")},
+			fields:    []jModel.KeyValue{jModel.String("http.url", "log")},
+		},
+		{
+			name:      "rename onto an existing tag",
+			processor: model.AttributeProcessor{Action: ProcessorRename, Key: "a", To: "b"},
+			span:      processedSpan("a", "b"),
+			tags:      []jModel.KeyValue{jModel.String("a", "span"), jModel.String("b", "span")},
+			process:   []jModel.KeyValue{jModel.String("a", "process"), jModel.String("b", "process")},
+			fields:    []jModel.KeyValue{jModel.String("a", "log"), jModel.String("b", "log")},
+			warnings:  1,
+		},
+		{
+			name:      "drop",
+			processor: model.AttributeProcessor{Action: ProcessorDrop, Key: "http.user_agent"},
+			span:      processedSpan("http.user_agent"),
+			tags:      []jModel.KeyValue{},
+			process:   []jModel.KeyValue{jModel.String("http.user_agent", "process")},
+			fields:    []jModel.KeyValue{jModel.String("http.user_agent", "log")},
+		},
+		{
+			name:      "drop from every target",
+			processor: model.AttributeProcessor{Action: ProcessorDrop, Key: "password", Targets: []string{TargetSpan, TargetProcess, TargetLog}},
+			span:      processedSpan("password"),
+			tags:      []jModel.KeyValue{},
+			process:   []jModel.KeyValue{},
+			fields:    []jModel.KeyValue{},
+		},
+		{
+			name:      "set on the process",
+			processor: model.AttributeProcessor{Action: ProcessorSet, Key: "env", Value: "production", Targets: []string{TargetProcess}},
+			span:      processedSpan("env"),
+			tags:      []jModel.KeyValue{jModel.String("env", "span")},
+			process:   []jModel.KeyValue{jModel.String("env", "production")},
+			fields:    []jModel.KeyValue{jModel.String("env", "log")},
+		},
+		{
+			name:      "copy from process",
+			processor: model.AttributeProcessor{Action: ProcessorCopyFromProcess, Key: "hostname", To: "host.name", Targets: []string{TargetLog}},
+			span:      processedSpan("hostname"),
+			tags:      []jModel.KeyValue{jModel.String("hostname", "span"), jModel.String("host.name", "process")},
+			process:   []jModel.KeyValue{jModel.String("hostname", "process")},
+			fields:    []jModel.KeyValue{jModel.String("hostname", "log")},
+		},
+		{
+			name:      "extract",
+			processor: model.AttributeProcessor{Action: ProcessorExtract, Key: "status", To: "status_class", Pattern: "^(s)pan$", Replacement: "${1}xx"},
+			span:      processedSpan("status"),
+			tags:      []jModel.KeyValue{jModel.String("status", "span"), jModel.String("status_class", "sxx")},
+			process:   []jModel.KeyValue{jModel.String("status", "process")},
+			fields:    []jModel.KeyValue{jModel.String("status", "log")},
+		},
+		{
+			name:      "extract not matching",
+			processor: model.AttributeProcessor{Action: ProcessorExtract, Key: "status", To: "status_class", Pattern: "^[0-9]+$"},
+			span:      processedSpan("status"),
+			tags:      []jModel.KeyValue{jModel.String("status", "span")},
+			process:   []jModel.KeyValue{jModel.String("status", "process")},
+			fields:    []jModel.KeyValue{jModel.String("status", "log")},
+			warnings:  1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			processor, err := newAttributeProcessor([]model.AttributeProcessor{test.processor})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			process, logs := test.span.Process, test.span.Logs
+			processTags, fields := append([]jModel.KeyValue{}, process.Tags...), append([]jModel.KeyValue{}, logs[0].Fields...)
+
+			processor.Process(test.span)
+
+			if !reflect.DeepEqual(test.span.Tags, test.tags) {
+				t.Errorf("span tags %v, want %v", test.span.Tags, test.tags)
+			}
+			if !reflect.DeepEqual(test.span.Process.Tags, test.process) {
+				t.Errorf("process tags %v, want %v", test.span.Process.Tags, test.process)
+			}
+			if !reflect.DeepEqual(test.span.Logs[0].Fields, test.fields) {
+				t.Errorf("log fields %v, want %v", test.span.Logs[0].Fields, test.fields)
+			}
+			if len(test.span.Warnings) != test.warnings {
+				t.Errorf("warnings %v, want %d", test.span.Warnings, test.warnings)
+			}
+			if !reflect.DeepEqual(process.Tags, processTags) || !reflect.DeepEqual(logs[0].Fields, fields) {
+				t.Error("the shared process or logs were changed in place")
+			}
+		})
+	}
+}
+
+func TestNewAttributeProcessorRejectsInvalidSteps(t *testing.T) {
+	for _, processor := range []model.AttributeProcessor{
+		{Action: ProcessorDrop},
+		{Action: ProcessorRename, Key: "a"},
+		{Action: ProcessorCopyFromProcess, Key: "a"},
+		{Action: ProcessorExtract, Key: "a", To: "b", Pattern: "("},
+		{Action: "uppercase", Key: "a"},
+		{Action: ProcessorDrop, Key: "a", Targets: []string{"resource"}},
+	} {
+		if _, err := newAttributeProcessor([]model.AttributeProcessor{processor}); err == nil {
+			t.Errorf("processor %+v: expected an error", processor)
+		}
+	}
+}
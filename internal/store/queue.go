@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	// OverflowBlock makes the caller wait until there is room in the queue.
+	OverflowBlock = "block"
+	// OverflowDropNewest rejects the incoming span when the queue is full.
+	OverflowDropNewest = "drop-newest"
+	// OverflowDropOldest evicts the oldest queued span to make room for the incoming one.
+	OverflowDropOldest = "drop-oldest"
+)
+
+var (
+	errQueueFull   = errors.New("span queue is full")
+	errQueueClosed = errors.New("span queue is closed")
+)
+
+// spanQueue decouples the callers of WriteSpan from Redis by buffering spans in memory
+// and writing them from a fixed pool of workers.
+type spanQueue struct {
+	logger hclog.Logger
+	policy string
+	write  func(ctx context.Context, span *jModel.Span) error
+	items  chan *jModel.Span
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newSpanQueue(logger hclog.Logger, capacity int, workers int, policy string, write func(ctx context.Context, span *jModel.Span) error) (*spanQueue, error) {
+	switch policy {
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest:
+	default:
+		return nil, fmt.Errorf("invalid queue overflow policy: %s", policy)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	q := &spanQueue{
+		logger: logger,
+		policy: policy,
+		write:  write,
+		items:  make(chan *jModel.Span, capacity),
+	}
+
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+
+	return q, nil
+}
+
+// Enqueue accepts the span for an asynchronous write, applying the overflow policy when the queue is full.
+func (q *spanQueue) Enqueue(ctx context.Context, span *jModel.Span) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+
+	defer metrics.QueueDepth.Set(float64(len(q.items)))
+
+	switch q.policy {
+	case OverflowDropNewest:
+		select {
+		case q.items <- span:
+			return nil
+		default:
+			metrics.QueueDroppedSpans.WithLabelValues(q.policy).Inc()
+			return errQueueFull
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.items <- span:
+				return nil
+			default:
+			}
+
+			select {
+			case <-q.items:
+				metrics.QueueDroppedSpans.WithLabelValues(q.policy).Inc()
+			default:
+			}
+		}
+	default:
+		select {
+		case q.items <- span:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close stops accepting spans and waits until every queued span has been written.
+func (q *spanQueue) Close() error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.items)
+	}
+	q.mu.Unlock()
+
+	q.wg.Wait()
+	metrics.QueueDepth.Set(0)
+	return nil
+}
+
+func (q *spanQueue) work() {
+	defer q.wg.Done()
+
+	for span := range q.items {
+		metrics.QueueDepth.Set(float64(len(q.items)))
+
+		if err := q.write(context.Background(), span); err != nil {
+			q.logger.Error("error to write queued span", "err", err)
+		}
+	}
+}
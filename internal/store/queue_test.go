@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+// blockedWrites records the written spans, each write waiting until release is closed.
+type blockedWrites struct {
+	release chan struct{}
+	mu      sync.Mutex
+	traces  []uint64
+}
+
+func (b *blockedWrites) write(ctx context.Context, span *jModel.Span) error {
+	<-b.release
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.traces = append(b.traces, span.TraceID.Low)
+	return nil
+}
+
+func TestSpanQueueOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		errs   int
+		want   []uint64
+	}{
+		{policy: OverflowDropNewest, errs: 2, want: []uint64{1, 2, 3}},
+		{policy: OverflowDropOldest, want: []uint64{1, 4, 5}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			writes := &blockedWrites{release: make(chan struct{})}
+			queue, err := newSpanQueue(hclog.NewNullLogger(), 2, 1, test.policy, writes.write)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The worker takes the first span and waits on it, the queue then holds 2 spans at most.
+			if err := queue.Enqueue(context.Background(), testSpan(1, "api")); err != nil {
+				t.Fatal(err)
+			}
+			for len(queue.items) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			errs := 0
+			for traceID := uint64(2); traceID <= 5; traceID++ {
+				if err := queue.Enqueue(context.Background(), testSpan(traceID, "api")); err != nil {
+					errs++
+				}
+			}
+
+			close(writes.release)
+			queue.Close()
+
+			if errs != test.errs {
+				t.Errorf("%d spans rejected, want %d", errs, test.errs)
+			}
+			if len(writes.traces) != len(test.want) {
+				t.Fatalf("wrote %v, want %v", writes.traces, test.want)
+			}
+			for i := range test.want {
+				if writes.traces[i] != test.want[i] {
+					t.Fatalf("wrote %v, want %v", writes.traces, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSpanQueueBlocksUntilTheContextEnds(t *testing.T) {
+	writes := &blockedWrites{release: make(chan struct{})}
+	queue, err := newSpanQueue(hclog.NewNullLogger(), 1, 1, OverflowBlock, writes.write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		close(writes.release)
+		queue.Close()
+	}()
+
+	queue.Enqueue(context.Background(), testSpan(1, "api"))
+	for len(queue.items) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	queue.Enqueue(context.Background(), testSpan(2, "api"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := queue.Enqueue(ctx, testSpan(3, "api")); err != context.DeadlineExceeded {
+		t.Errorf("expected the full queue to block until the deadline, got %v", err)
+	}
+}
+
+func TestSpanQueueRejectsInvalidPolicyAndClosedQueue(t *testing.T) {
+	if _, err := newSpanQueue(hclog.NewNullLogger(), 1, 1, "drop-random", nil); err == nil {
+		t.Error("expected an invalid policy to be rejected")
+	}
+
+	queue, err := newSpanQueue(hclog.NewNullLogger(), 1, 1, OverflowBlock, func(ctx context.Context, span *jModel.Span) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue.Close()
+	if err := queue.Enqueue(context.Background(), testSpan(1, "api")); err != errQueueClosed {
+		t.Errorf("expected the closed queue to reject spans, got %v", err)
+	}
+}
@@ -8,7 +8,6 @@ import (
 
 	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
 	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
-	"github.com/nicolastakashi/jaeger-redisearch/internal/redis"
 	"github.com/nicolastakashi/jaeger-redisearch/internal/repository"
 
 	"github.com/hashicorp/go-hclog"
@@ -79,7 +78,7 @@ func (s *SpanReader) GetOperations(ctx context.Context, query spanstore.Operatio
 	array := make([]spanstore.Operation, len(operations))
 	for i, operation := range operations {
 		array[i] = spanstore.Operation{
-			Name:     redis.UnTokenization(operation.OperationName),
+			Name:     operation.OperationName,
 			SpanKind: operation.SpanKind,
 		}
 	}
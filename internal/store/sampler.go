@@ -0,0 +1,156 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	// SamplingKeep stores the matched spans.
+	SamplingKeep = "keep"
+	// SamplingDrop discards the matched spans.
+	SamplingDrop = "drop"
+	// SamplingProbabilistic stores the matched spans of a share of the traces, given by the rule rate.
+	SamplingProbabilistic = "probabilistic"
+
+	defaultSamplingRule = "default"
+)
+
+// sampler decides which spans are stored from an ordered list of rules, the first rule matching a span decides.
+// Spans matching no rule are kept. Decisions are taken span by span, so a trace whose spans match different rules
+// may be stored partially; probabilistic rules compare the same trace hash, so their decisions are nested by rate.
+type sampler struct {
+	rules []samplingRule
+}
+
+type samplingRule struct {
+	model.SamplingRule
+	// threshold is the trace hash under which a probabilistic rule keeps the trace.
+	threshold uint64
+}
+
+func newSampler(rules []model.SamplingRule) (*sampler, error) {
+	s := &sampler{rules: make([]samplingRule, 0, len(rules))}
+
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+
+		compiled := samplingRule{SamplingRule: rule}
+		switch rule.Action {
+		case SamplingKeep, SamplingDrop:
+		case SamplingProbabilistic:
+			if rule.Rate < 0 || rule.Rate > 1 {
+				return nil, fmt.Errorf("invalid rate of sampling rule %s: %v", rule.Name, rule.Rate)
+			}
+			compiled.threshold = uint64(rule.Rate * math.MaxUint64)
+			if rule.Rate == 1 {
+				compiled.threshold = math.MaxUint64
+			}
+		default:
+			return nil, fmt.Errorf("invalid action of sampling rule %s: %s", rule.Name, rule.Action)
+		}
+
+		s.rules = append(s.rules, compiled)
+	}
+
+	return s, nil
+}
+
+// Sample reports whether the span should be stored.
+// Probabilistic decisions only depend on the trace id, so all spans of a trace matched by the same rule are kept or dropped together.
+func (s *sampler) Sample(span *jModel.Span) bool {
+	for _, rule := range s.rules {
+		if !rule.matches(span) {
+			continue
+		}
+
+		keep := rule.Action == SamplingKeep || (rule.Action == SamplingProbabilistic && traceHash(span.TraceID) <= rule.threshold)
+		metrics.SamplingDecisions.WithLabelValues(rule.Name, decision(keep)).Inc()
+		return keep
+	}
+
+	metrics.SamplingDecisions.WithLabelValues(defaultSamplingRule, decision(true)).Inc()
+	return true
+}
+
+func (r samplingRule) matches(span *jModel.Span) bool {
+	if r.Service != "" && (span.Process == nil || span.Process.ServiceName != r.Service) {
+		return false
+	}
+
+	if r.Operation != "" && span.OperationName != r.Operation {
+		return false
+	}
+
+	if r.MinDuration > 0 && span.Duration < r.MinDuration {
+		return false
+	}
+
+	if r.MaxDuration > 0 && span.Duration > r.MaxDuration {
+		return false
+	}
+
+	for key, value := range r.Tags {
+		if !hasTag(span, key, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasTag(span *jModel.Span, key string, value string) bool {
+	for _, tag := range span.Tags {
+		if tag.Key == key && tag.AsString() == value {
+			return true
+		}
+	}
+
+	if span.Process == nil {
+		return false
+	}
+
+	for _, tag := range span.Process.Tags {
+		if tag.Key == key && tag.AsString() == value {
+			return true
+		}
+	}
+	return false
+}
+
+// traceHash spreads trace ids evenly over uint64. The id is hashed rather than used as is
+// because clients sampling probabilistically already keep only the traces with the lowest ids.
+// FNV barely changes the high bits for ids differing in their last bytes, so its sum is mixed
+// with the murmur3 finalizer before being compared to thresholds.
+func traceHash(traceID jModel.TraceID) uint64 {
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data, traceID.High)
+	binary.BigEndian.PutUint64(data[8:], traceID.Low)
+
+	h := fnv.New64a()
+	h.Write(data)
+
+	sum := h.Sum64()
+	sum ^= sum >> 33
+	sum *= 0xff51afd7ed558ccd
+	sum ^= sum >> 33
+	sum *= 0xc4ceb9fe1a85ec53
+	sum ^= sum >> 33
+	return sum
+}
+
+func decision(keep bool) string {
+	if keep {
+		return SamplingKeep
+	}
+	return SamplingDrop
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+func newTestSampler(t *testing.T, rules ...model.SamplingRule) *sampler {
+	t.Helper()
+
+	s, err := newSampler(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSamplerRules(t *testing.T) {
+	slow := testSpan(1, "api")
+	slow.Duration = 2 * time.Second
+
+	tests := []struct {
+		name  string
+		rules []model.SamplingRule
+		span  *jModel.Span
+		want  bool
+	}{
+		{
+			name: "no rule keeps the span",
+			span: testSpan(1, "api"),
+			want: true,
+		},
+		{
+			name:  "drop by service",
+			rules: []model.SamplingRule{{Service: "api", Action: SamplingDrop}},
+			span:  testSpan(1, "api"),
+		},
+		{
+			name:  "rule of another service",
+			rules: []model.SamplingRule{{Service: "web", Action: SamplingDrop}},
+			span:  testSpan(1, "api"),
+			want:  true,
+		},
+		{
+			name:  "drop by operation",
+			rules: []model.SamplingRule{{Operation: "operation", Action: SamplingDrop}},
+			span:  testSpan(1, "api"),
+		},
+		{
+			name:  "drop by span tag",
+			rules: []model.SamplingRule{{Tags: map[string]string{"http.target": "/health"}, Action: SamplingDrop}},
+			span:  testSpan(1, "api", jModel.String("http.target", "/health")),
+		},
+		{
+			name:  "tag of another value",
+			rules: []model.SamplingRule{{Tags: map[string]string{"http.target": "/health"}, Action: SamplingDrop}},
+			span:  testSpan(1, "api", jModel.String("http.target", "/users")),
+			want:  true,
+		},
+		{
+			name:  "first matching rule decides",
+			rules: []model.SamplingRule{{MinDuration: time.Second, Action: SamplingKeep}, {Service: "api", Action: SamplingDrop}},
+			span:  slow,
+			want:  true,
+		},
+		{
+			name:  "duration over the maximum",
+			rules: []model.SamplingRule{{MaxDuration: time.Second, Action: SamplingDrop}},
+			span:  slow,
+			want:  true,
+		},
+		{
+			name:  "rate of 0",
+			rules: []model.SamplingRule{{Action: SamplingProbabilistic, Rate: 0}},
+			span:  testSpan(1, "api"),
+		},
+		{
+			name:  "rate of 1",
+			rules: []model.SamplingRule{{Action: SamplingProbabilistic, Rate: 1}},
+			span:  testSpan(1, "api"),
+			want:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := newTestSampler(t, test.rules...).Sample(test.span); got != test.want {
+				t.Errorf("sampled %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewSamplerRejectsInvalidRules(t *testing.T) {
+	for _, rule := range []model.SamplingRule{
+		{Action: "sometimes"},
+		{Action: SamplingProbabilistic, Rate: -0.1},
+		{Action: SamplingProbabilistic, Rate: 1.5},
+	} {
+		if _, err := newSampler([]model.SamplingRule{rule}); err == nil {
+			t.Errorf("rule %+v: expected an error", rule)
+		}
+	}
+}
+
+// TestSamplerTraceConsistency checks spans of a trace matched by the same probabilistic rule are kept together, traces
+// kept at a rate are kept at every higher rate, and documents that spans matching other rules are decided on their own.
+func TestSamplerTraceConsistency(t *testing.T) {
+	s := newTestSampler(t,
+		model.SamplingRule{Name: "health", Operation: "GET /health", Action: SamplingDrop},
+		model.SamplingRule{Name: "api", Service: "api", Action: SamplingProbabilistic, Rate: 0.2},
+		model.SamplingRule{Name: "web", Service: "web", Action: SamplingProbabilistic, Rate: 0.5},
+	)
+
+	kept := 0
+	for traceID := uint64(1); traceID <= 1000; traceID++ {
+		api := s.Sample(testSpan(traceID, "api"))
+		other := testSpan(traceID, "api")
+		other.SpanID++
+		if s.Sample(other) != api {
+			t.Fatalf("trace %d: spans matched by the same rule decided apart", traceID)
+		}
+		if api && !s.Sample(testSpan(traceID, "web")) {
+			t.Fatalf("trace %d: kept at rate 0.2 but dropped at rate 0.5", traceID)
+		}
+		if api {
+			kept++
+		}
+
+		health := testSpan(traceID, "api")
+		health.OperationName = "GET /health"
+		if s.Sample(health) {
+			t.Fatalf("trace %d: health check span kept", traceID)
+		}
+	}
+
+	if kept < 150 || kept > 250 {
+		t.Errorf("kept %d traces out of 1000 at rate 0.2", kept)
+	}
+}
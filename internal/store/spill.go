@@ -0,0 +1,205 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+const spillRecordHeaderSize = 4
+
+var (
+	errSpillFull    = errors.New("spill file is full")
+	errSpillCorrupt = errors.New("spill record runs past the end of the file")
+)
+
+// spillFile is a size-capped append-only file on local disk holding the spans that could not be written to Redis.
+// Each record is a span encoded as protobuf, prefixed by its length.
+//
+// Replayed records are only dropped once the whole file has been replayed, so spans replayed before a crash
+// are replayed again on the next start, which is harmless since writing a span twice stores it once.
+type spillFile struct {
+	maxSize int64
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	offset  int64
+}
+
+func openSpillFile(path string, maxSize int64) (*spillFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	// A crash in the middle of an append leaves a truncated record at the end of the file.
+	size := lastCompleteRecord(file)
+
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &spillFile{
+		maxSize: maxSize,
+		file:    file,
+		size:    size,
+	}, nil
+}
+
+// Append adds the span at the end of the file, failing when the file would exceed its maximum size.
+func (f *spillFile) Append(span *jModel.Span) error {
+	data, err := span.Marshal()
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, spillRecordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[spillRecordHeaderSize:], data)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size+int64(len(record)) > f.maxSize {
+		return errSpillFull
+	}
+
+	if _, err := f.file.Write(record); err != nil {
+		return err
+	}
+
+	f.size += int64(len(record))
+	return nil
+}
+
+// Pending reports whether some spans were not replayed yet.
+func (f *spillFile) Pending() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.offset < f.size
+}
+
+// Replay hands the spilled spans over to write in the order they were spilled, at most batchSize at a time.
+// It stops at the first batch write fails on, so this batch is replayed again on the next call.
+// Records that cannot be decoded are discarded, and so is the rest of the file once a record length runs past its end,
+// as the records after it cannot be found.
+func (f *spillFile) Replay(batchSize int, write func(spans []*jModel.Span) error) error {
+	f.mu.Lock()
+	offset, size := f.offset, f.size
+	f.mu.Unlock()
+
+	reader := bufio.NewReader(io.NewSectionReader(f.file, offset, size-offset))
+
+	for offset < size {
+		spans := make([]*jModel.Span, 0, batchSize)
+		read := int64(0)
+		for len(spans) < batchSize && offset+read < size {
+			data, err := readSpillRecord(reader, size-offset-read)
+			if err != nil {
+				metrics.SpilledSpans.WithLabelValues("discarded").Inc()
+				read = size - offset
+				break
+			}
+			read += int64(spillRecordHeaderSize + len(data))
+
+			span := &jModel.Span{}
+			if err := span.Unmarshal(data); err != nil {
+				metrics.SpilledSpans.WithLabelValues("discarded").Inc()
+				continue
+			}
+			spans = append(spans, span)
+		}
+
+		if len(spans) > 0 {
+			if err := write(spans); err != nil {
+				return err
+			}
+		}
+
+		offset += read
+		metrics.SpilledSpans.WithLabelValues("replayed").Add(float64(len(spans)))
+
+		f.mu.Lock()
+		f.offset = offset
+		f.mu.Unlock()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Spans appended while replaying are replayed on the next call, the file is only emptied once all of them are.
+	if f.offset < f.size {
+		return nil
+	}
+
+	if err := f.file.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	f.size, f.offset = 0, 0
+	return nil
+}
+
+func (f *spillFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+// readSpillRecord reads the next record, failing when its length runs past the remaining bytes of the file.
+func readSpillRecord(reader io.Reader, remaining int64) ([]byte, error) {
+	header := make([]byte, spillRecordHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	length := int64(binary.BigEndian.Uint32(header))
+	if length > remaining-spillRecordHeaderSize {
+		return nil, errSpillCorrupt
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func lastCompleteRecord(file *os.File) int64 {
+	reader := bufio.NewReader(file)
+	header := make([]byte, spillRecordHeaderSize)
+
+	size := int64(0)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return size
+		}
+
+		length := int64(binary.BigEndian.Uint32(header))
+		if _, err := reader.Discard(int(length)); err != nil {
+			return size
+		}
+
+		size += spillRecordHeaderSize + length
+	}
+}
@@ -0,0 +1,227 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+func openTestSpillFile(t *testing.T, maxSize int64) (*spillFile, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "spill")
+	spill, err := openSpillFile(path, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { spill.Close() })
+	return spill, path
+}
+
+// replayAll replays the file, returning the trace ids of the replayed spans in order.
+func replayAll(t *testing.T, spill *spillFile, batchSize int) []uint64 {
+	t.Helper()
+
+	var replayed []uint64
+	err := spill.Replay(batchSize, func(spans []*jModel.Span) error {
+		if len(spans) > batchSize {
+			t.Fatalf("replayed a batch of %d spans, more than %d", len(spans), batchSize)
+		}
+		for _, span := range spans {
+			replayed = append(replayed, span.TraceID.Low)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return replayed
+}
+
+func appendSpans(t *testing.T, spill *spillFile, traceIDs ...uint64) {
+	t.Helper()
+
+	for _, traceID := range traceIDs {
+		if err := spill.Append(testSpan(traceID, "api")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSpillReplaysInOrder(t *testing.T) {
+	spill, _ := openTestSpillFile(t, 1<<20)
+	appendSpans(t, spill, 1, 2, 3, 4, 5)
+
+	if !spill.Pending() {
+		t.Fatal("expected spilled spans to be pending")
+	}
+
+	replayed := replayAll(t, spill, 2)
+	if len(replayed) != 5 {
+		t.Fatalf("replayed %v, want 5 spans", replayed)
+	}
+	for i, traceID := range replayed {
+		if traceID != uint64(i+1) {
+			t.Fatalf("replayed %v, want the spilling order", replayed)
+		}
+	}
+
+	if spill.Pending() || spill.size != 0 {
+		t.Errorf("expected the file to be emptied once replayed, size %d", spill.size)
+	}
+}
+
+// TestSpillResumesAfterFailedBatch checks the batch write fails on is replayed again, and the batches before it are not.
+func TestSpillResumesAfterFailedBatch(t *testing.T) {
+	spill, _ := openTestSpillFile(t, 1<<20)
+	appendSpans(t, spill, 1, 2, 3, 4)
+
+	unavailable := errors.New("redis unavailable")
+	batches := 0
+	err := spill.Replay(2, func(spans []*jModel.Span) error {
+		batches++
+		if batches == 2 {
+			return unavailable
+		}
+		return nil
+	})
+	if err != unavailable {
+		t.Fatalf("expected the write error, got %v", err)
+	}
+
+	if replayed := replayAll(t, spill, 2); len(replayed) != 2 || replayed[0] != 3 || replayed[1] != 4 {
+		t.Errorf("replayed %v after the failure, want [3 4]", replayed)
+	}
+}
+
+func TestSpillMaxSize(t *testing.T) {
+	// The spans share their start time, so their records have the same size.
+	spans := []*jModel.Span{testSpan(1, "api"), testSpan(2, "api"), testSpan(3, "api")}
+	for _, span := range spans {
+		span.StartTime = spans[0].StartTime
+	}
+
+	data, err := spans[0].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordSize := int64(spillRecordHeaderSize + len(data))
+
+	spill, _ := openTestSpillFile(t, 2*recordSize)
+	for _, span := range spans[:2] {
+		if err := spill.Append(span); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := spill.Append(spans[2]); err != errSpillFull {
+		t.Fatalf("expected the file to be full, got %v", err)
+	}
+
+	replayAll(t, spill, 10)
+	if err := spill.Append(testSpan(3, "api")); err != nil {
+		t.Errorf("expected room once replayed, got %v", err)
+	}
+}
+
+func TestSpillCorruptRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, path string)
+		want    []uint64
+	}{
+		{
+			name: "truncated record is dropped on open",
+			corrupt: func(t *testing.T, path string) {
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Truncate(path, info.Size()-3); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []uint64{1},
+		},
+		{
+			name: "record that does not decode is discarded",
+			corrupt: func(t *testing.T, path string) {
+				appendRaw(t, path, []byte{0xff, 0xff, 0xff})
+			},
+			want: []uint64{1, 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spill, path := openTestSpillFile(t, 1<<20)
+			appendSpans(t, spill, 1, 2)
+			spill.Close()
+
+			test.corrupt(t, path)
+
+			reopened, err := openSpillFile(path, 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.Close()
+
+			replayed := replayAll(t, reopened, 10)
+			if len(replayed) != len(test.want) {
+				t.Fatalf("replayed %v, want %v", replayed, test.want)
+			}
+			for i := range replayed {
+				if replayed[i] != test.want[i] {
+					t.Fatalf("replayed %v, want %v", replayed, test.want)
+				}
+			}
+			if reopened.Pending() {
+				t.Error("expected no pending span once replayed")
+			}
+		})
+	}
+}
+
+// TestSpillRecordRunningPastTheEnd checks a record whose length runs past the end of the file discards the rest of the file
+// instead of blocking the replay.
+func TestSpillRecordRunningPastTheEnd(t *testing.T) {
+	spill, _ := openTestSpillFile(t, 1<<20)
+	appendSpans(t, spill, 1)
+	second := spill.size
+	appendSpans(t, spill, 2, 3)
+
+	header := make([]byte, spillRecordHeaderSize)
+	binary.BigEndian.PutUint32(header, 1<<30)
+	if _, err := spill.file.WriteAt(header, second); err != nil {
+		t.Fatal(err)
+	}
+
+	if replayed := replayAll(t, spill, 10); len(replayed) != 1 || replayed[0] != 1 {
+		t.Errorf("replayed %v, want [1]", replayed)
+	}
+	if spill.Pending() {
+		t.Error("expected no pending span once replayed")
+	}
+}
+
+// appendRaw appends a record holding data to the file at path.
+func appendRaw(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	record := make([]byte, spillRecordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[spillRecordHeaderSize:], data)
+	if _, err := file.Write(record); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"context"
+
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+// StreamingSpanWriter receives the spans Jaeger pushes over the streaming writer API.
+// The collector never waits for an acknowledgement of a streamed span, and any error returned
+// terminates the whole stream, so spans are handed over without waiting for their flush
+// and failures are only logged.
+type StreamingSpanWriter struct {
+	writer *SpanWriter
+}
+
+func NewStreamingSpanWriter(writer *SpanWriter) *StreamingSpanWriter {
+	return &StreamingSpanWriter{
+		writer: writer,
+	}
+}
+
+// WriteSpan processes the span the way SpanWriter.WriteSpan does, redacting, sampling and rate limiting it before it is stored.
+func (s *StreamingSpanWriter) WriteSpan(ctx context.Context, span *jModel.Span) error {
+	if err := s.writer.ingest(ctx, span, false); err != nil {
+		s.writer.logger.Error("error to write streamed span", "err", err)
+	}
+
+	return nil
+}
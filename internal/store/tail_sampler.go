@@ -0,0 +1,321 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	tailPolicyError        = "error"
+	tailPolicyRootDuration = "root-duration"
+	tailPolicyService      = "service"
+	tailPolicyRate         = "probabilistic"
+	tailPolicyNone         = "none"
+
+	// tailWriteBatchSize is the number of spans of kept traces written at once.
+	tailWriteBatchSize = 1000
+)
+
+// tailSampler buffers spans per trace for a decision window, then writes or discards whole traces
+// depending on what the trace turned out to contain.
+// The decision of a trace is remembered for another window, so its late spans follow it.
+// Kept spans are written in the background, so adding a span never waits for Redis unless writes fall behind.
+type tailSampler struct {
+	logger          hclog.Logger
+	write           func(ctx context.Context, spans []*jModel.Span) []error
+	wait            time.Duration
+	maxTraces       int
+	maxSpans        int
+	keepErrors      bool
+	minRootDuration time.Duration
+	services        map[string]bool
+	threshold       uint64
+	mu              sync.Mutex
+	traces          map[jModel.TraceID]*list.Element
+	pending         *list.List
+	buffered        int
+	decisions       map[jModel.TraceID]bool
+	decided         *list.List
+	stop            chan struct{}
+	done            chan struct{}
+	writesMu        sync.Mutex
+	writesCond      *sync.Cond
+	writes          []*jModel.Span
+	writesClosed    bool
+	writesDone      chan struct{}
+}
+
+type pendingTrace struct {
+	id      jModel.TraceID
+	arrival time.Time
+	spans   []*jModel.Span
+}
+
+type decidedTrace struct {
+	spans []*jModel.Span
+	keep  bool
+}
+
+type tailDecision struct {
+	id jModel.TraceID
+	at time.Time
+}
+
+func newTailSampler(logger hclog.Logger, config model.Configuration, write func(ctx context.Context, spans []*jModel.Span) []error) (*tailSampler, error) {
+	if config.TailSamplingRate < 0 || config.TailSamplingRate > 1 {
+		return nil, fmt.Errorf("invalid tail sampling rate: %v", config.TailSamplingRate)
+	}
+
+	services := make(map[string]bool, len(config.TailSamplingServices))
+	for _, service := range config.TailSamplingServices {
+		services[service] = true
+	}
+
+	threshold := uint64(config.TailSamplingRate * math.MaxUint64)
+	if config.TailSamplingRate == 1 {
+		threshold = math.MaxUint64
+	}
+
+	t := &tailSampler{
+		logger:          logger,
+		write:           write,
+		wait:            config.TailSamplingWait,
+		maxTraces:       config.TailSamplingMaxTraces,
+		maxSpans:        config.TailSamplingMaxSpans,
+		keepErrors:      config.TailSamplingErrors,
+		minRootDuration: config.TailSamplingRootDuration,
+		services:        services,
+		threshold:       threshold,
+		traces:          make(map[jModel.TraceID]*list.Element),
+		pending:         list.New(),
+		decisions:       make(map[jModel.TraceID]bool),
+		decided:         list.New(),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+		writesDone:      make(chan struct{}),
+	}
+	t.writesCond = sync.NewCond(&t.writesMu)
+	go t.run()
+	go t.runWrites()
+	return t, nil
+}
+
+// Add buffers the span until its trace is decided.
+// When the buffer holds too many traces or spans, the oldest traces are decided right away.
+func (t *tailSampler) Add(span *jModel.Span) {
+	t.mu.Lock()
+
+	if keep, ok := t.decisions[span.TraceID]; ok {
+		t.mu.Unlock()
+		t.flush([]*jModel.Span{span}, keep)
+		return
+	}
+
+	if element, ok := t.traces[span.TraceID]; ok {
+		trace := element.Value.(*pendingTrace)
+		trace.spans = append(trace.spans, span)
+	} else {
+		t.traces[span.TraceID] = t.pending.PushBack(&pendingTrace{
+			id:      span.TraceID,
+			arrival: time.Now(),
+			spans:   []*jModel.Span{span},
+		})
+	}
+	t.buffered++
+
+	evicted := []decidedTrace{}
+	for (t.maxTraces > 0 && t.pending.Len() > t.maxTraces) || (t.maxSpans > 0 && t.buffered > t.maxSpans) {
+		trace := t.pending.Front().Value.(*pendingTrace)
+		evicted = append(evicted, decidedTrace{spans: trace.spans, keep: t.decide(trace, time.Now())})
+	}
+
+	t.observe()
+	t.mu.Unlock()
+
+	for _, trace := range evicted {
+		t.flush(trace.spans, trace.keep)
+	}
+}
+
+// Close decides every buffered trace and writes the ones kept.
+func (t *tailSampler) Close() error {
+	close(t.stop)
+	<-t.done
+
+	t.writesMu.Lock()
+	t.writesClosed = true
+	t.writesCond.Broadcast()
+	t.writesMu.Unlock()
+
+	<-t.writesDone
+	return nil
+}
+
+func (t *tailSampler) run() {
+	defer close(t.done)
+
+	interval := t.wait / 10
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			t.decideExpired(time.Time{})
+			return
+		case now := <-ticker.C:
+			t.decideExpired(now)
+		}
+	}
+}
+
+// decideExpired decides the traces whose window is over at now, or all of them when now is zero.
+func (t *tailSampler) decideExpired(now time.Time) {
+	t.mu.Lock()
+
+	traces := []decidedTrace{}
+	for element := t.pending.Front(); element != nil; element = t.pending.Front() {
+		trace := element.Value.(*pendingTrace)
+		if !now.IsZero() && now.Sub(trace.arrival) < t.wait {
+			break
+		}
+		traces = append(traces, decidedTrace{spans: trace.spans, keep: t.decide(trace, now)})
+	}
+
+	for element := t.decided.Front(); element != nil && !now.IsZero(); element = t.decided.Front() {
+		decision := element.Value.(*tailDecision)
+		if now.Sub(decision.at) < t.wait {
+			break
+		}
+		t.decided.Remove(element)
+		delete(t.decisions, decision.id)
+	}
+
+	t.observe()
+	t.mu.Unlock()
+
+	for _, trace := range traces {
+		t.flush(trace.spans, trace.keep)
+	}
+}
+
+// decide removes the trace from the buffer and records whether it is kept. It must be called with the lock held.
+func (t *tailSampler) decide(trace *pendingTrace, now time.Time) bool {
+	t.pending.Remove(t.traces[trace.id])
+	delete(t.traces, trace.id)
+	t.buffered -= len(trace.spans)
+
+	policy := t.policy(trace)
+	keep := policy != tailPolicyNone
+
+	t.decisions[trace.id] = keep
+	t.decided.PushBack(&tailDecision{id: trace.id, at: now})
+
+	metrics.TailSamplingDecisions.WithLabelValues(policy, decision(keep)).Inc()
+	return keep
+}
+
+// policy returns the first policy keeping the trace, or tailPolicyNone when the trace is dropped.
+func (t *tailSampler) policy(trace *pendingTrace) string {
+	for _, span := range trace.spans {
+		if t.keepErrors && isError(span) {
+			return tailPolicyError
+		}
+
+		if t.minRootDuration > 0 && span.ParentSpanID() == 0 && span.Duration >= t.minRootDuration {
+			return tailPolicyRootDuration
+		}
+
+		if span.Process != nil && t.services[span.Process.ServiceName] {
+			return tailPolicyService
+		}
+	}
+
+	if t.threshold > 0 && traceHash(trace.id) <= t.threshold {
+		return tailPolicyRate
+	}
+
+	return tailPolicyNone
+}
+
+// observe reports the size of the buffer. It must be called with the lock held.
+func (t *tailSampler) observe() {
+	metrics.TailSamplingBufferedTraces.Set(float64(t.pending.Len()))
+	metrics.TailSamplingBufferedSpans.Set(float64(t.buffered))
+}
+
+// flush hands the spans of a kept trace over to the background writes. It waits while the writes fall behind
+// by as many spans as the buffer holds at most, so memory stays bounded when Redis is slow.
+func (t *tailSampler) flush(spans []*jModel.Span, keep bool) {
+	if !keep {
+		metrics.TailSamplingSpans.WithLabelValues(decision(false)).Add(float64(len(spans)))
+		return
+	}
+
+	metrics.TailSamplingSpans.WithLabelValues(decision(true)).Add(float64(len(spans)))
+
+	t.writesMu.Lock()
+	defer t.writesMu.Unlock()
+
+	for t.maxSpans > 0 && len(t.writes) > 0 && len(t.writes)+len(spans) > t.maxSpans && !t.writesClosed {
+		t.writesCond.Wait()
+	}
+	t.writes = append(t.writes, spans...)
+	metrics.TailSamplingPendingWrites.Set(float64(len(t.writes)))
+	t.writesCond.Broadcast()
+}
+
+// runWrites writes the spans handed over by flush, until Close is called and every span is written.
+func (t *tailSampler) runWrites() {
+	defer close(t.writesDone)
+
+	for {
+		t.writesMu.Lock()
+		for len(t.writes) == 0 && !t.writesClosed {
+			t.writesCond.Wait()
+		}
+		if len(t.writes) == 0 {
+			t.writesMu.Unlock()
+			return
+		}
+
+		n := len(t.writes)
+		if n > tailWriteBatchSize {
+			n = tailWriteBatchSize
+		}
+		spans := t.writes[:n:n]
+		t.writes = t.writes[n:]
+		metrics.TailSamplingPendingWrites.Set(float64(len(t.writes)))
+		t.writesCond.Broadcast()
+		t.writesMu.Unlock()
+
+		for _, err := range t.write(context.Background(), spans) {
+			if err != nil {
+				t.logger.Error("error to write sampled trace", "err", err)
+			}
+		}
+	}
+}
+
+func isError(span *jModel.Span) bool {
+	for _, tag := range span.Tags {
+		if tag.Key == "error" && tag.AsString() == "true" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+func newTestTailSampler(t *testing.T, config model.Configuration, write func(ctx context.Context, spans []*jModel.Span) []error) *tailSampler {
+	t.Helper()
+
+	if config.TailSamplingWait == 0 {
+		config.TailSamplingWait = time.Hour
+	}
+	sampler, err := newTailSampler(hclog.NewNullLogger(), config, write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sampler
+}
+
+func TestTailSamplerPolicies(t *testing.T) {
+	slowRoot := testSpan(1, "api")
+	slowRoot.Duration = 2 * time.Second
+
+	tests := []struct {
+		name   string
+		config model.Configuration
+		spans  []*jModel.Span
+		want   int
+	}{
+		{
+			name:   "no policy drops the trace",
+			config: model.Configuration{},
+			spans:  []*jModel.Span{testSpan(1, "api"), childSpan(1, "api")},
+		},
+		{
+			name:   "error keeps the whole trace",
+			config: model.Configuration{TailSamplingErrors: true},
+			spans:  []*jModel.Span{testSpan(1, "api"), errorSpan(1, "api")},
+			want:   2,
+		},
+		{
+			name:   "slow root",
+			config: model.Configuration{TailSamplingRootDuration: time.Second},
+			spans:  []*jModel.Span{slowRoot, childSpan(1, "api")},
+			want:   2,
+		},
+		{
+			name:   "fast root",
+			config: model.Configuration{TailSamplingRootDuration: time.Second},
+			spans:  []*jModel.Span{testSpan(1, "api")},
+		},
+		{
+			name:   "service",
+			config: model.Configuration{TailSamplingServices: []string{"web"}},
+			spans:  []*jModel.Span{testSpan(1, "api"), childSpan(1, "web")},
+			want:   2,
+		},
+		{
+			name:   "rate of 1",
+			config: model.Configuration{TailSamplingRate: 1},
+			spans:  []*jModel.Span{testSpan(1, "api")},
+			want:   1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			written := &persisted{}
+			sampler := newTestTailSampler(t, test.config, written.persist)
+			for _, span := range test.spans {
+				sampler.Add(span)
+			}
+			sampler.Close()
+
+			if spans := written.all(); len(spans) != test.want {
+				t.Errorf("wrote %d spans, want %d", len(spans), test.want)
+			}
+		})
+	}
+}
+
+func TestNewTailSamplerRejectsInvalidRate(t *testing.T) {
+	for _, rate := range []float64{-0.1, 1.5} {
+		if _, err := newTailSampler(hclog.NewNullLogger(), model.Configuration{TailSamplingRate: rate}, nil); err == nil {
+			t.Errorf("rate %v: expected an error", rate)
+		}
+	}
+}
+
+// TestTailSamplerBoundsItsBufferBySpans checks a trace growing past the span limit is decided early, and late spans
+// follow its decision.
+func TestTailSamplerBoundsItsBufferBySpans(t *testing.T) {
+	written := &persisted{}
+	sampler := newTestTailSampler(t, model.Configuration{TailSamplingMaxSpans: 3, TailSamplingServices: []string{"api"}}, written.persist)
+	defer sampler.Close()
+
+	for i := 0; i < 4; i++ {
+		sampler.Add(childSpan(1, "api"))
+	}
+	sampler.Add(childSpan(2, "web"))
+
+	sampler.mu.Lock()
+	buffered, traces := sampler.buffered, sampler.pending.Len()
+	sampler.mu.Unlock()
+	if buffered != 1 || traces != 1 {
+		t.Errorf("buffer holds %d spans of %d traces, want the span of trace 2", buffered, traces)
+	}
+
+	sampler.Add(childSpan(1, "api"))
+	waitForSpans(t, written, 5)
+}
+
+// TestTailSamplerWritesInTheBackground checks adding spans does not wait for the writes of kept traces,
+// until the writes fall behind by as many spans as the buffer holds.
+func TestTailSamplerWritesInTheBackground(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	written := 0
+	write := func(ctx context.Context, spans []*jModel.Span) []error {
+		<-release
+		mu.Lock()
+		written += len(spans)
+		mu.Unlock()
+		return make([]error, len(spans))
+	}
+
+	sampler := newTestTailSampler(t, model.Configuration{TailSamplingMaxSpans: 2, TailSamplingRate: 1}, write)
+
+	added := make(chan struct{})
+	go func() {
+		for traceID := uint64(1); traceID <= 4; traceID++ {
+			sampler.Add(testSpan(traceID, "api"))
+		}
+		close(added)
+	}()
+
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("adding spans waited for a blocked write")
+	}
+
+	close(release)
+	sampler.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if written != 4 {
+		t.Errorf("wrote %d spans, want 4", written)
+	}
+}
+
+func waitForSpans(t *testing.T, written *persisted, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for len(written.all()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("wrote %d spans, want %d", len(written.all()), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
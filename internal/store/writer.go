@@ -2,7 +2,11 @@ package store
 
 import (
 	"context"
+	"time"
 
+	"github.com/nicolastakashi/jaeger-redisearch/internal/metrics"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/redaction"
 	"github.com/nicolastakashi/jaeger-redisearch/internal/repository"
 
 	"github.com/hashicorp/go-hclog"
@@ -10,31 +14,299 @@ import (
 )
 
 type SpanWriter struct {
-	logger              hclog.Logger
-	spanRepository      *repository.SpanRepository
-	operationRepository *repository.OperationRepository
+	logger         hclog.Logger
+	spanRepository *repository.SpanRepository
+	stream         *repository.SpanStream
+	processor      *attributeProcessor
+	redactor       *redaction.Redactor
+	sampler        *sampler
+	limiter        *rateLimiter
+	tailSampler    *tailSampler
+	batcher        *spanBatcher
+	queue          *spanQueue
+	spill          *spillFile
+	persist        func(ctx context.Context, spans []*jModel.Span) []error
+	repersist      func(ctx context.Context, spans []*jModel.Span) []error
+	replayInterval time.Duration
+	replayBatch    int
+	stopReplay     chan struct{}
+	replayDone     chan struct{}
 }
 
-func NewSpanWriter(logger hclog.Logger, spanRepository *repository.SpanRepository, serviceRepository *repository.OperationRepository) *SpanWriter {
-	return &SpanWriter{
-		logger:              logger,
-		spanRepository:      spanRepository,
-		operationRepository: serviceRepository,
+// NewSpanWriter creates a writer storing spans through spanRepository,
+// or appending them to stream for an Indexer to store when stream is not nil.
+func NewSpanWriter(logger hclog.Logger, spanRepository *repository.SpanRepository, stream *repository.SpanStream, config model.Configuration) (*SpanWriter, error) {
+	writer := &SpanWriter{
+		logger:         logger,
+		spanRepository: spanRepository,
+		stream:         stream,
+		persist:        spanRepository.WriteBatch,
+		repersist:      spanRepository.RewriteBatch,
 	}
+
+	// Spans replayed to the stream were never appended, the indexer counts them against the trace limit.
+	if stream != nil {
+		writer.persist = stream.Append
+		writer.repersist = stream.Append
+	}
+
+	if len(config.AttributeProcessors) > 0 {
+		processor, err := newAttributeProcessor(config.AttributeProcessors)
+		if err != nil {
+			return nil, err
+		}
+		writer.processor = processor
+	}
+
+	redactor, err := redaction.New(config)
+	if err != nil {
+		return nil, err
+	}
+	writer.redactor = redactor
+
+	if len(config.SamplingRules) > 0 {
+		sampler, err := newSampler(config.SamplingRules)
+		if err != nil {
+			return nil, err
+		}
+		writer.sampler = sampler
+	}
+
+	if config.RateLimitService > 0 || config.RateLimitGlobal > 0 || len(config.RateLimitServices) > 0 {
+		limiter, err := newRateLimiter(config)
+		if err != nil {
+			return nil, err
+		}
+		writer.limiter = limiter
+	}
+
+	if config.TailSampling {
+		tailSampler, err := newTailSampler(logger, config, writer.writeBatch)
+		if err != nil {
+			return nil, err
+		}
+		writer.tailSampler = tailSampler
+	}
+
+	if config.SpillPath != "" {
+		spill, err := openSpillFile(config.SpillPath, config.SpillMaxSize)
+		if err != nil {
+			return nil, err
+		}
+		writer.spill = spill
+		writer.replayInterval = config.SpillReplay
+		writer.replayBatch = config.BatchSize
+		if writer.replayBatch < 1 {
+			writer.replayBatch = 1
+		}
+		writer.stopReplay = make(chan struct{})
+		writer.replayDone = make(chan struct{})
+		go writer.replay()
+	}
+
+	if config.BatchSize > 1 {
+		writer.batcher = newSpanBatcher(logger, config.BatchSize, config.BatchLinger, writer.writeBatch)
+	}
+
+	if config.QueueCapacity > 0 {
+		queue, err := newSpanQueue(logger, config.QueueCapacity, config.QueueWorkers, config.QueueOverflow, writer.write)
+		if err != nil {
+			return nil, err
+		}
+		writer.queue = queue
+	}
+
+	return writer, nil
 }
 
 func (s *SpanWriter) WriteSpan(ctx context.Context, span *jModel.Span) error {
-	err := s.operationRepository.Write(ctx, span)
+	return s.ingest(ctx, span, true)
+}
 
-	if err != nil {
-		s.logger.Error("error to write service", err)
-		return err
+// WriteSpans writes the spans the way WriteSpan does, for receivers getting many spans in a request.
+// Every span is handed over to the batcher before waiting for the flushes, so the spans share batches
+// instead of waiting for the batch linger one after another. The returned slice holds the result of each span.
+func (s *SpanWriter) WriteSpans(ctx context.Context, spans []*jModel.Span) []error {
+	errs := make([]error, len(spans))
+
+	admitted := make([]*jModel.Span, 0, len(spans))
+	owners := make([]int, 0, len(spans))
+	for i, span := range spans {
+		if s.prepare(span) {
+			admitted = append(admitted, span)
+			owners = append(owners, i)
+		}
 	}
 
-	err = s.spanRepository.Write(ctx, span)
-	if err != nil {
-		s.logger.Error("error to write span", err)
+	switch {
+	case s.tailSampler != nil:
+		for _, span := range admitted {
+			s.tailSampler.Add(span)
+		}
+	case s.queue != nil:
+		for n, span := range admitted {
+			errs[owners[n]] = s.queue.Enqueue(ctx, span)
+		}
+	case s.batcher != nil:
+		requests := make([]*batchRequest, len(admitted))
+		for n, span := range admitted {
+			requests[n], errs[owners[n]] = s.batcher.Submit(ctx, span)
+		}
+		for n, request := range requests {
+			if request == nil {
+				continue
+			}
+			select {
+			case errs[owners[n]] = <-request.result:
+			case <-ctx.Done():
+				errs[owners[n]] = ctx.Err()
+			}
+		}
+	case len(admitted) > 0:
+		for n, err := range s.writeBatch(ctx, admitted) {
+			errs[owners[n]] = err
+		}
+	}
+
+	return errs
+}
+
+// prepare runs the span through the processor, redactor, sampler and rate limiter, and reports whether it is to be written.
+func (s *SpanWriter) prepare(span *jModel.Span) bool {
+	if s.processor != nil {
+		s.processor.Process(span)
+	}
+
+	if s.redactor != nil {
+		s.redactor.Redact(span)
+	}
+
+	if s.sampler != nil && !s.sampler.Sample(span) {
+		return false
+	}
+
+	// Spans are shed silently, failing the write would only make the collector retry them.
+	return s.limiter == nil || s.limiter.Allow(span)
+}
+
+// ingest prepares the span, then hands it over to the tail sampler, the queue, the batcher or Redis.
+// Both the span writer and the streaming span writer write through it.
+// When wait is false, a batched span is handed over without waiting for its flush.
+func (s *SpanWriter) ingest(ctx context.Context, span *jModel.Span, wait bool) error {
+	if !s.prepare(span) {
+		return nil
+	}
+
+	if s.tailSampler != nil {
+		s.tailSampler.Add(span)
+		return nil
+	}
+
+	if s.queue != nil {
+		return s.queue.Enqueue(ctx, span)
+	}
+
+	if !wait && s.batcher != nil {
+		_, err := s.batcher.Submit(ctx, span)
 		return err
 	}
+	return s.write(ctx, span)
+}
+
+// Close drains the write queue and flushes the spans still waiting to be written.
+// It must be called before the Redis client is closed.
+func (s *SpanWriter) Close() error {
+	if s.tailSampler != nil {
+		s.tailSampler.Close()
+	}
+	if s.queue != nil {
+		s.queue.Close()
+	}
+	if s.batcher != nil {
+		s.batcher.Close()
+	}
+	if s.spill != nil {
+		close(s.stopReplay)
+		<-s.replayDone
+		return s.spill.Close()
+	}
 	return nil
 }
+
+func (s *SpanWriter) write(ctx context.Context, span *jModel.Span) error {
+	if s.batcher != nil {
+		return s.batcher.Write(ctx, span)
+	}
+	return s.writeBatch(ctx, []*jModel.Span{span})[0]
+}
+
+// writeBatch persists the spans, spilling the ones that cannot be written to local disk when spilling is enabled.
+// Only the spans failing because Redis is unavailable are spilled, the ones Redis rejects would be rejected again on replay.
+func (s *SpanWriter) writeBatch(ctx context.Context, spans []*jModel.Span) []error {
+	errs := s.persist(ctx, spans)
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		if s.spill == nil || !repository.IsUnavailable(err) {
+			s.logger.Error("error to write span", "err", err)
+			continue
+		}
+
+		if spillErr := s.spill.Append(spans[i]); spillErr != nil {
+			s.logger.Error("error to spill span", "err", spillErr, "writeErr", err)
+			metrics.SpilledSpans.WithLabelValues("discarded").Inc()
+			continue
+		}
+
+		metrics.SpilledSpans.WithLabelValues("spilled").Inc()
+		errs[i] = nil
+	}
+	return errs
+}
+
+// replay writes the spilled spans back once Redis is healthy again.
+func (s *SpanWriter) replay() {
+	defer close(s.replayDone)
+
+	ticker := time.NewTicker(s.replayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopReplay:
+			return
+		case <-ticker.C:
+		}
+
+		if !s.spill.Pending() {
+			continue
+		}
+
+		ctx := context.Background()
+		if err := s.spanRepository.Ping(ctx); err != nil {
+			continue
+		}
+
+		// Replay stops while Redis is unavailable, the spans Redis rejects are discarded so they do not block the ones after them.
+		err := s.spill.Replay(s.replayBatch, func(spans []*jModel.Span) error {
+			for i, err := range s.repersist(ctx, spans) {
+				if err == nil {
+					continue
+				}
+				if repository.IsUnavailable(err) {
+					return err
+				}
+				s.logger.Error("error to replay spilled span, discarding it", "err", err, "traceID", spans[i].TraceID)
+				metrics.SpilledSpans.WithLabelValues("discarded").Inc()
+			}
+			return nil
+		})
+
+		if err != nil {
+			s.logger.Warn("error to replay spilled spans", "err", err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicolastakashi/jaeger-redisearch/internal/model"
+	"github.com/nicolastakashi/jaeger-redisearch/internal/repository"
+
+	"github.com/hashicorp/go-hclog"
+	jModel "github.com/jaegertracing/jaeger/model"
+)
+
+// persisted records the spans a writer persists instead of writing them to Redis.
+type persisted struct {
+	mu    sync.Mutex
+	spans []*jModel.Span
+}
+
+func (p *persisted) persist(ctx context.Context, spans []*jModel.Span) []error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.spans = append(p.spans, spans...)
+	return make([]error, len(spans))
+}
+
+func (p *persisted) all() []*jModel.Span {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*jModel.Span{}, p.spans...)
+}
+
+// newTestWriter creates a writer persisting its spans to the returned recorder.
+func newTestWriter(t *testing.T, config model.Configuration) (*SpanWriter, *persisted) {
+	t.Helper()
+
+	writer, err := NewSpanWriter(hclog.NewNullLogger(), nil, nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &persisted{}
+	writer.persist = recorder.persist
+	writer.repersist = recorder.persist
+	return writer, recorder
+}
+
+func testSpan(traceID uint64, service string, tags ...jModel.KeyValue) *jModel.Span {
+	return &jModel.Span{
+		TraceID:       jModel.NewTraceID(0, traceID),
+		SpanID:        jModel.NewSpanID(traceID),
+		OperationName: "operation",
+		StartTime:     time.Now(),
+		Duration:      time.Millisecond,
+		Tags:          tags,
+		Process:       jModel.NewProcess(service, nil),
+	}
+}
+
+func tagValue(kvs []jModel.KeyValue, key string) (string, bool) {
+	for _, kv := range kvs {
+		if kv.Key == key {
+			return kv.AsString(), true
+		}
+	}
+	return "", false
+}
+
+// TestWritersRedactAndSample checks spans are redacted and sampled before they are persisted,
+// whether they are written through the span writer or the streaming span writer, batched or queued.
+func TestWritersRedactAndSample(t *testing.T) {
+	tests := []struct {
+		name   string
+		config model.Configuration
+	}{
+		{name: "direct"},
+		{name: "batched", config: model.Configuration{BatchSize: 10, BatchLinger: time.Millisecond}},
+		{name: "queued", config: model.Configuration{QueueCapacity: 10, QueueWorkers: 2, QueueOverflow: OverflowBlock}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			config.RedactionRules = []model.RedactionRule{{Key: "password", Action: "mask"}}
+			config.SamplingRules = []model.SamplingRule{{Service: "noisy", Action: SamplingDrop}}
+
+			writer, recorder := newTestWriter(t, config)
+			streaming := NewStreamingSpanWriter(writer)
+
+			ctx := context.Background()
+			if err := writer.WriteSpan(ctx, testSpan(1, "api", jModel.String("password", "hunter2"))); err != nil {
+				t.Fatal(err)
+			}
+			if err := streaming.WriteSpan(ctx, testSpan(2, "api", jModel.String("password", "hunter2"))); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.WriteSpan(ctx, testSpan(3, "noisy")); err != nil {
+				t.Fatal(err)
+			}
+			if err := streaming.WriteSpan(ctx, testSpan(4, "noisy")); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			spans := recorder.all()
+			if len(spans) != 2 {
+				t.Fatalf("expected the 2 spans of api to be persisted, got %d", len(spans))
+			}
+			for _, span := range spans {
+				if span.Process.ServiceName != "api" {
+					t.Fatalf("span of %s was not sampled out", span.Process.ServiceName)
+				}
+				if value, _ := tagValue(span.Tags, "password"); value != "[REDACTED]" {
+					t.Fatalf("span %v persisted with password %q", span.TraceID, value)
+				}
+			}
+		})
+	}
+}
+
+// TestWritersRedactBeforePersisting checks the tags, process tags and log fields of the spans are redacted
+// before the span writer and the streaming span writer persist them.
+func TestWritersRedactBeforePersisting(t *testing.T) {
+	writer, recorder := newTestWriter(t, model.Configuration{
+		RedactionKey:       "secret",
+		RedactionRules:     []model.RedactionRule{{Key: "user.id", Action: "hash"}},
+		RedactionDetectors: []model.RedactionDetector{{Name: "email", Action: "mask"}},
+	})
+	streaming := NewStreamingSpanWriter(writer)
+
+	newSpan := func(traceID uint64) *jModel.Span {
+		span := testSpan(traceID, "api", jModel.String("user.id", "42"))
+		span.Process.Tags = []jModel.KeyValue{jModel.String("owner", "jane@example.com")}
+		span.Logs = []jModel.Log{{Timestamp: span.StartTime, Fields: []jModel.KeyValue{jModel.String("message", "mail jane@example.com")}}}
+		return span
+	}
+
+	ctx := context.Background()
+	if err := writer.WriteSpan(ctx, newSpan(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := streaming.WriteSpan(ctx, newSpan(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.all()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 persisted spans, got %d", len(spans))
+	}
+	for _, span := range spans {
+		if value, _ := tagValue(span.Tags, "user.id"); value == "42" || value == "" {
+			t.Errorf("span %v persisted with user.id %q", span.TraceID, value)
+		}
+		if value, _ := tagValue(span.Process.Tags, "owner"); value != "[REDACTED]" {
+			t.Errorf("span %v persisted with process tag owner %q", span.TraceID, value)
+		}
+		if value, _ := tagValue(span.Logs[0].Fields, "message"); value != "mail [REDACTED]" {
+			t.Errorf("span %v persisted with log field message %q", span.TraceID, value)
+		}
+	}
+}
+
+// TestWriterSpillsOnlyWhenRedisIsUnavailable checks the spans Redis rejects are not spilled, as they would be rejected again on replay.
+func TestWriterSpillsOnlyWhenRedisIsUnavailable(t *testing.T) {
+	writer, _ := newTestWriter(t, model.Configuration{
+		SpillPath:    filepath.Join(t.TempDir(), "spill"),
+		SpillMaxSize: 1 << 20,
+		SpillReplay:  time.Hour,
+	})
+	defer writer.Close()
+
+	rejected := errors.New("ERR invalid document")
+	writer.persist = func(ctx context.Context, spans []*jModel.Span) []error {
+		return []error{repository.ErrCircuitOpen, rejected, context.DeadlineExceeded}
+	}
+
+	errs := writer.writeBatch(context.Background(), []*jModel.Span{testSpan(1, "api"), testSpan(2, "api"), testSpan(3, "api")})
+	if errs[0] != nil || errs[2] != nil {
+		t.Errorf("expected the spans failing while Redis is unavailable to be spilled, got %v", errs)
+	}
+	if errs[1] != rejected {
+		t.Errorf("expected the rejected span to fail, got %v", errs[1])
+	}
+
+	replayed := replayAll(t, writer.spill, 10)
+	if len(replayed) != 2 || replayed[0] != 1 || replayed[1] != 3 {
+		t.Errorf("spilled %v, want [1 3]", replayed)
+	}
+}
+
+// TestWriteSpansSharesBatches checks the spans written at once wait for a single batch linger, not one each.
+func TestWriteSpansSharesBatches(t *testing.T) {
+	const linger = 20 * time.Millisecond
+
+	writer, recorder := newTestWriter(t, model.Configuration{BatchSize: 1000, BatchLinger: linger})
+	defer writer.Close()
+
+	spans := spansOf(100, func(i int) *jModel.Span { return testSpan(uint64(i+1), "api") })
+
+	start := time.Now()
+	for i, err := range writer.WriteSpans(context.Background(), spans) {
+		if err != nil {
+			t.Fatalf("span %d: %v", i, err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed >= 2*linger {
+		t.Errorf("writing 100 spans took %v, more than a batch linger of %v", elapsed, linger)
+	}
+	if len(recorder.all()) != 100 {
+		t.Errorf("persisted %d spans, want 100", len(recorder.all()))
+	}
+}